@@ -0,0 +1,307 @@
+package main
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+const defaultPageLimit = 100
+const maxPageLimit = 1000
+
+// checkAdmin applies the usual IP check and then requires the configured
+// basic auth credentials. Admin endpoints are disabled when no credentials
+// are configured.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+  if !checkAccess(w, r) {
+    return false
+  }
+  auth := currentConfig().BasicAuth
+  if auth.Username == "" || auth.HashedPassword == "" {
+    http.Error(w, "Admin access is not configured", http.StatusForbidden)
+    return false
+  }
+  user, pass, ok := r.BasicAuth()
+  sum := sha256.Sum256([]byte(pass))
+  userOK := subtle.ConstantTimeCompare([]byte(user), []byte(auth.Username)) == 1
+  passOK := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(auth.HashedPassword))) == 1
+  if !ok || !userOK || !passOK {
+    w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+    http.Error(w, "Unauthorized", http.StatusUnauthorized)
+    fmt.Println("Unauthorized admin access for: ", r.RemoteAddr)
+    return false
+  }
+  return true
+}
+
+// pagination reads ?offset= and ?limit= and clamps them to [0, total].
+func pagination(r *http.Request, total int) (offset, limit int) {
+  offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+  limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+  if offset < 0 || offset > total {
+    offset = total
+  }
+  if limit <= 0 {
+    limit = defaultPageLimit
+  }
+  if limit > maxPageLimit {
+    limit = maxPageLimit
+  }
+  return offset, limit
+}
+
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+
+  prefix := r.URL.Query().Get("prefix")
+  files := []FileEntry{}
+  for _, entry := range currentIndex().Files {
+    if strings.HasPrefix(entry.Path, prefix) {
+      files = append(files, entry)
+    }
+  }
+
+  offset, limit := pagination(r, len(files))
+  end := offset + limit
+  if end > len(files) {
+    end = len(files)
+  }
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "total": len(files),
+    "offset": offset,
+    "limit": limit,
+    "files": files[offset:end],
+  })
+}
+
+type cacheStats struct {
+  Entries int `json:"entries"`
+  Hits int64 `json:"hits"`
+  Misses int64 `json:"misses"`
+}
+
+type indexStats struct {
+  Documents int `json:"documents"`
+  Tokens int `json:"tokens"`
+  IndexedBytes int64 `json:"indexed_bytes"`
+  Built time.Time `json:"built"`
+  BuildMS int64 `json:"build_ms"`
+  Cache cacheStats `json:"cache"`
+  QueryCache cacheStats `json:"query_cache"`
+}
+
+// handleStats reports the size of the current index and how the text and
+// query caches are doing, for monitoring.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  idx := currentIndex()
+  writeJSON(w, http.StatusOK, indexStats{
+    Documents: len(idx.Docs),
+    Tokens: idx.Tokens,
+    IndexedBytes: idx.Bytes,
+    Built: idx.Built,
+    BuildMS: idx.Duration.Milliseconds(),
+    Cache: cacheStats{
+      Entries: texts.Load().Len(),
+      Hits: textCacheHits.Load(),
+      Misses: textCacheMisses.Load(),
+    },
+    QueryCache: cacheStats{
+      Entries: queries.Load().Len(),
+      Hits: queryCacheHits.Load(),
+      Misses: queryCacheMisses.Load(),
+    },
+  })
+}
+
+const redacted = "[REDACTED]"
+
+func redactConfig(c Config) Config {
+  if c.BasicAuth.HashedPassword != "" {
+    c.BasicAuth.HashedPassword = redacted
+  }
+  if c.WebhookSecret != "" {
+    c.WebhookSecret = redacted
+  }
+  if c.CookieSecret != "" {
+    c.CookieSecret = redacted
+  }
+  return c
+}
+
+// handleAdminConfig returns the config in effect, with secrets redacted, or
+// on POST updates it.
+func handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  switch r.Method {
+  case http.MethodGet:
+    writeJSON(w, http.StatusOK, redactConfig(*currentConfig()))
+  case http.MethodPost:
+    handleAdminConfigUpdate(w, r)
+  default:
+    w.Header().Set("Allow", "GET, POST")
+    writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+  }
+}
+
+const maxConfigBodySize = 64 << 10
+
+var configUpdateMu sync.Mutex
+
+// applyConfigPatch decodes the JSON object patch over c. Lists are replaced
+// and templates and features merged; secrets sent back as redacted keep their value.
+// encoding/json decodes into the backing arrays of non-nil slices, so they
+// are copied first: c is usually a copy of the running config, which must
+// not change if the patch is rejected.
+func applyConfigPatch(c *Config, patch []byte) error {
+  c.IPRanges = append([]IPRange(nil), c.IPRanges...)
+  c.CORSOrigins = append([]string(nil), c.CORSOrigins...)
+  mounts := make([]Mount, len(c.Mounts))
+  for i, m := range c.Mounts {
+    m.IPRanges = append([]IPRange(nil), m.IPRanges...)
+    mounts[i] = m
+  }
+  c.Mounts = mounts
+  templates := map[string]string{}
+  for name, path := range c.Templates {
+    templates[name] = path
+  }
+  c.Templates = templates
+  features := map[string]bool{}
+  for name, enabled := range c.Features {
+    features[name] = enabled
+  }
+  c.Features = features
+  auth, secret, cookieSecret := c.BasicAuth.HashedPassword, c.WebhookSecret, c.CookieSecret
+  dec := json.NewDecoder(bytes.NewReader(patch))
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(c); err != nil {
+    return err
+  }
+  if c.BasicAuth.HashedPassword == redacted {
+    c.BasicAuth.HashedPassword = auth
+  }
+  if c.WebhookSecret == redacted {
+    c.WebhookSecret = secret
+  }
+  if c.CookieSecret == redacted {
+    c.CookieSecret = cookieSecret
+  }
+  return nil
+}
+
+// keepStartupSettings copies the settings only read at startup from old
+// to c.
+func keepStartupSettings(c, old *Config) {
+  c.Port = old.Port
+  c.ListenAddr = old.ListenAddr
+  c.SocketMode = old.SocketMode
+  c.AllowEphemeralPort = old.AllowEphemeralPort
+  c.Directory = old.Directory
+  c.Mounts = old.Mounts
+}
+
+// applyRuntimeSettings sets up the caches, recent queries and analytics
+// for c, keeping those whose settings are the same in old. old is nil at
+// startup. Analytics are saved to the old file before switching, and
+// resizing a cache or the recent queries list starts it empty.
+func applyRuntimeSettings(c, old *Config) {
+  if old == nil || c.DisableRecentQueries != old.DisableRecentQueries || c.RecentQueriesSize != old.RecentQueriesSize {
+    var q *recentQueries
+    if !c.DisableRecentQueries {
+      q = newRecentQueries(c.RecentQueriesSize)
+    }
+    recent.Store(q)
+  }
+  if old == nil || c.TextCacheSize != old.TextCacheSize {
+    texts.Store(newTextCache(c.TextCacheSize))
+  }
+  if old == nil || c.SearchCacheMaxEntries != old.SearchCacheMaxEntries || c.SearchCacheTTLSeconds != old.SearchCacheTTLSeconds {
+    queries.Store(newQueryCache(c.SearchCacheMaxEntries, time.Duration(c.SearchCacheTTLSeconds)*time.Second))
+  }
+  if old == nil || c.DisableAnalytics != old.DisableAnalytics || c.AnalyticsFile != old.AnalyticsFile {
+    if old != nil {
+      if err := usage.Load().Save(old.AnalyticsFile); err != nil {
+        fmt.Println("Error saving analytics: ", err)
+      }
+    }
+    var a *analytics
+    if !c.DisableAnalytics {
+      a = loadAnalytics(c.AnalyticsFile)
+    }
+    usage.Store(a)
+  }
+}
+
+// handleAdminConfigUpdate merges a partial config into the running one and
+// into the config file. Env and flag overrides stay out of the file, as the
+// patch is applied to what is on disk rather than to the running config.
+// Settings only read at startup, such as port and directory, are saved but
+// apply after a restart: the running config keeps the values the listener,
+// /static/, the mounts and the index were set up with.
+func handleAdminConfigUpdate(w http.ResponseWriter, r *http.Request) {
+  patch, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBodySize))
+  if err != nil {
+    writeJSONError(w, http.StatusBadRequest, "error reading body")
+    return
+  }
+  if err := checkDuplicateKeys(patch); err != nil {
+    writeJSONError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+
+  configUpdateMu.Lock()
+  defer configUpdateMu.Unlock()
+  running := *currentConfig()
+  if err := applyConfigPatch(&running, patch); err != nil {
+    writeJSONError(w, http.StatusBadRequest, "invalid config: "+err.Error())
+    return
+  }
+  if err := validateConfig(running); err != nil {
+    writeJSONError(w, http.StatusBadRequest, err.Error())
+    return
+  }
+  saved, err := loadConfig(configPath)
+  if err == nil {
+    err = applyConfigPatch(&saved, patch)
+  }
+  if err != nil {
+    fmt.Println("Error saving config: ", err)
+    writeJSONError(w, http.StatusInternalServerError, "error saving config")
+    return
+  }
+  // The file can differ from the running config through env and flag
+  // overrides, so it is checked too: the server must start from it.
+  if err := validateConfig(saved); err != nil {
+    writeJSONError(w, http.StatusBadRequest, "saved config: "+err.Error())
+    return
+  }
+  if err := writeConfigFile(configPath, saved); err != nil {
+    fmt.Println("Error saving config: ", err)
+    writeJSONError(w, http.StatusInternalServerError, "error saving config")
+    return
+  }
+  old := currentConfig()
+  keepStartupSettings(&running, old)
+  setConfig(&running)
+  applyRuntimeSettings(&running, old)
+  warnIPCheckDisabled(running)
+  reloadTemplates()
+  fmt.Println("Config updated by", r.RemoteAddr)
+  writeJSON(w, http.StatusOK, redactConfig(running))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+const (
+  testAdminUser = "admin"
+  testAdminPassword = "secret"
+)
+
+// withAdmin sets up basic auth for testAdminUser and testAdminPassword.
+func withAdmin(c *Config) {
+  sum := sha256.Sum256([]byte(testAdminPassword))
+  c.BasicAuth = BasicAuth{Username: testAdminUser, HashedPassword: hex.EncodeToString(sum[:])}
+}
+
+// admin sends a request with the admin credentials to handler.
+func admin(handler http.HandlerFunc, method, target string, body io.Reader) *httptest.ResponseRecorder {
+  w := httptest.NewRecorder()
+  r := httptest.NewRequest(method, target, body)
+  r.SetBasicAuth(testAdminUser, testAdminPassword)
+  handler(w, r)
+  return w
+}
+
+// useCaches turns the text and query caches on for the test.
+func useCaches(t *testing.T) {
+  oldTexts, oldQueries := texts.Load(), queries.Load()
+  texts.Store(newTextCache(100))
+  queries.Store(newQueryCache(100, time.Minute))
+  t.Cleanup(func() {
+    texts.Store(oldTexts)
+    queries.Store(oldQueries)
+  })
+}
+
+func readStats(t *testing.T) indexStats {
+  t.Helper()
+  w := admin(handleStats, http.MethodGet, "/admin/stats", nil)
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  var stats indexStats
+  if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+    t.Fatal(err)
+  }
+  return stats
+}
+
+func TestStats(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "a.html": "<p>alpha beta</p>",
+    "b.html": "<p>beta gamma delta</p>",
+  }, withAdmin)
+  useCaches(t)
+
+  w := admin(handleStats, http.MethodGet, "/admin/stats", nil)
+  var fields map[string]interface{}
+  if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+    t.Fatal(err)
+  }
+  for _, key := range []string{"documents", "tokens", "indexed_bytes", "built", "build_ms", "cache", "query_cache"} {
+    if _, ok := fields[key]; !ok {
+      t.Errorf("no %q in %s", key, w.Body)
+    }
+  }
+
+  before := readStats(t)
+  if before.Documents != 2 || before.Tokens != 4 || before.IndexedBytes == 0 || before.Built.IsZero() {
+    t.Errorf("stats = %+v", before)
+  }
+  if before.Cache.Entries != 0 || before.QueryCache.Entries != 0 {
+    t.Errorf("caches not empty: %+v", before)
+  }
+  get(handleSearch, "/?q=beta")
+  get(handleSearch, "/?q=beta")
+  get(handleSearch, "/?q=gamma")
+  after := readStats(t)
+  if after.Cache.Entries != 2 || after.QueryCache.Entries != 2 {
+    t.Errorf("cache entries = %d and %d, want 2 and 2", after.Cache.Entries, after.QueryCache.Entries)
+  }
+  if hits := after.QueryCache.Hits - before.QueryCache.Hits; hits != 1 {
+    t.Errorf("query cache hits went up by %d, want 1", hits)
+  }
+  if misses := after.QueryCache.Misses - before.QueryCache.Misses; misses != 2 {
+    t.Errorf("query cache misses went up by %d, want 2", misses)
+  }
+  // The search for gamma reads both files from the text cache.
+  if hits := after.Cache.Hits - before.Cache.Hits; hits != 2 {
+    t.Errorf("text cache hits went up by %d, want 2", hits)
+  }
+
+  if w := get(handleStats, "/admin/stats"); w.Code != http.StatusUnauthorized {
+    t.Errorf("without credentials: status = %d, want 401", w.Code)
+  }
+}
+
+func TestAdminConfigGet(t *testing.T) {
+  cfg := useConfig(t, func(c *Config) {
+    withAdmin(c)
+    c.Port = "9123"
+    c.WebhookSecret = "s3cret-hook"
+    c.CookieSecret = "s3cret-cookie"
+  })
+  w := admin(handleAdminConfig, http.MethodGet, "/admin/config", nil)
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  for _, secret := range []string{cfg.BasicAuth.HashedPassword, "s3cret-hook", "s3cret-cookie"} {
+    if strings.Contains(w.Body.String(), secret) {
+      t.Errorf("secret %q in %s", secret, w.Body)
+    }
+  }
+  var got Config
+  if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+    t.Fatal(err)
+  }
+  if got.Port != "9123" || got.BasicAuth.Username != testAdminUser {
+    t.Errorf("port %q, user %q", got.Port, got.BasicAuth.Username)
+  }
+  if got.BasicAuth.HashedPassword != redacted || got.WebhookSecret != redacted || got.CookieSecret != redacted {
+    t.Errorf("secrets = %+v, %q, %q", got.BasicAuth, got.WebhookSecret, got.CookieSecret)
+  }
+  if currentConfig().BasicAuth.HashedPassword == redacted {
+    t.Error("redacting changed the running config")
+  }
+}
+
+// useConfigFile points configPath at a config file holding text for the
+// rest of the test, and returns its path.
+func useConfigFile(t *testing.T, text string) string {
+  t.Helper()
+  path := writeConfig(t, "config.json", text)
+  old := configPath
+  configPath = path
+  t.Cleanup(func() { configPath = old })
+  return path
+}
+
+func TestAdminConfigUpdateAppliesRuntimeSettings(t *testing.T) {
+  useConfig(t, func(c *Config) {
+    withAdmin(c)
+    c.DisableAnalytics = true
+    c.AnalyticsFile = filepath.Join(t.TempDir(), "analytics.json")
+  })
+  useConfigFile(t, "{}")
+  oldRecent, oldUsage := recent.Load(), usage.Load()
+  t.Cleanup(func() {
+    recent.Store(oldRecent)
+    usage.Store(oldUsage)
+  })
+  useCaches(t) // restores the caches afterwards
+  applyRuntimeSettings(currentConfig(), nil)
+  oldTexts, oldQueries := texts.Load(), queries.Load()
+
+  patch := `{"disableRecentQueries": true, "disableAnalytics": false, "textCacheSize": 7, "staticMaxAgeSeconds": 42, "reindexIntervalSeconds": 30}`
+  w := admin(handleAdminConfig, http.MethodPost, "/admin/config", strings.NewReader(patch))
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  if recent.Load() != nil {
+    t.Error("recent queries still recorded after disableRecentQueries")
+  }
+  if usage.Load() == nil {
+    t.Error("analytics not collected after disableAnalytics was turned off")
+  }
+  if texts.Load() == oldTexts {
+    t.Error("text cache not rebuilt for a new textCacheSize")
+  }
+  if queries.Load() != oldQueries {
+    t.Error("query cache rebuilt although its settings did not change")
+  }
+  if currentConfig().ReindexIntervalSeconds != 30 {
+    t.Errorf("reindexIntervalSeconds = %d, want 30", currentConfig().ReindexIntervalSeconds)
+  }
+
+  static := StaticCacheMiddleware(staticMaxAge)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte("body"))
+  }))
+  w = httptest.NewRecorder()
+  static.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page.html", nil))
+  if got := w.Header().Get("Cache-Control"); got != "public, max-age=42" {
+    t.Errorf("Cache-Control = %q, want the updated max-age", got)
+  }
+}
+
+func TestAdminConfigUpdateValidatesSavedConfig(t *testing.T) {
+  useConfig(t, withAdmin)
+  // The running port comes from a flag; the file's own would not start.
+  path := useConfigFile(t, `{"port": "0"}`)
+  w := admin(handleAdminConfig, http.MethodPost, "/admin/config", strings.NewReader(`{"textCacheSize": 7}`))
+  if w.Code != http.StatusBadRequest {
+    t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+  }
+  if data, err := os.ReadFile(path); err != nil || string(data) != `{"port": "0"}` {
+    t.Errorf("config file = %q, %v; want it unchanged", data, err)
+  }
+  if currentConfig().TextCacheSize == 7 {
+    t.Error("a rejected update changed the running config")
+  }
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "html/template"
+  "net/http"
+  "net/url"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+const (
+  analyticsTopCount = 20
+  analyticsKeepDays = 30
+  analyticsSaveInterval = time.Minute
+  analyticsDayFormat = "2006-01-02"
+)
+
+// analytics counts result click-throughs and searches per day, so the
+// dashboard can show what is popular over the last week or month. Days
+// older than analyticsKeepDays are dropped.
+type analytics struct {
+  mu sync.Mutex
+  Pages map[string]map[string]int `json:"pages"`
+  Queries map[string]map[string]int `json:"queries"`
+  dirty bool
+}
+
+// usage is nil when collection is disabled; its methods then do nothing.
+// It is swapped when /admin/config turns collection on or off or moves the
+// file.
+var usage atomic.Pointer[analytics]
+
+// loadAnalytics reads the counts saved at path. A missing file starts
+// empty; so does an unreadable one, after logging it.
+func loadAnalytics(path string) *analytics {
+  a := &analytics{Pages: map[string]map[string]int{}, Queries: map[string]map[string]int{}}
+  if path == "" {
+    return a
+  }
+  data, err := os.ReadFile(path)
+  if os.IsNotExist(err) {
+    return a
+  }
+  if err == nil {
+    err = json.Unmarshal(data, a)
+  }
+  if err != nil {
+    fmt.Println("Error loading analytics, starting empty: ", err)
+    return &analytics{Pages: map[string]map[string]int{}, Queries: map[string]map[string]int{}}
+  }
+  if a.Pages == nil {
+    a.Pages = map[string]map[string]int{}
+  }
+  if a.Queries == nil {
+    a.Queries = map[string]map[string]int{}
+  }
+  return a
+}
+
+func (a *analytics) count(days map[string]map[string]int, key string) {
+  day := time.Now().Format(analyticsDayFormat)
+  if days[day] == nil {
+    days[day] = map[string]int{}
+  }
+  days[day][key]++
+  a.dirty = true
+}
+
+// AddPage counts a click-through to the document at p.
+func (a *analytics) AddPage(p string) {
+  if a == nil {
+    return
+  }
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  a.count(a.Pages, p)
+}
+
+// AddQuery counts a search. Queries differing only in case or spacing are
+// counted together.
+func (a *analytics) AddQuery(query string) {
+  if a == nil || query == "" {
+    return
+  }
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  a.count(a.Queries, strings.ToLower(strings.Join(strings.Fields(query), " ")))
+}
+
+type analyticsEntry struct {
+  Key string `json:"key"`
+  Count int `json:"count"`
+}
+
+// top returns the analyticsTopCount keys with the highest totals over the
+// last days days, today included.
+func (a *analytics) top(byDay map[string]map[string]int, days int) []analyticsEntry {
+  since := time.Now().AddDate(0, 0, 1-days).Format(analyticsDayFormat)
+  totals := map[string]int{}
+  for day, counts := range byDay {
+    if day < since {
+      continue
+    }
+    for key, n := range counts {
+      totals[key] += n
+    }
+  }
+  entries := []analyticsEntry{}
+  for key, n := range totals {
+    entries = append(entries, analyticsEntry{Key: key, Count: n})
+  }
+  sort.Slice(entries, func(i, j int) bool {
+    if entries[i].Count != entries[j].Count {
+      return entries[i].Count > entries[j].Count
+    }
+    return entries[i].Key < entries[j].Key
+  })
+  if len(entries) > analyticsTopCount {
+    entries = entries[:analyticsTopCount]
+  }
+  return entries
+}
+
+// Top returns the most visited pages and most frequent queries over the
+// last days days.
+func (a *analytics) Top(days int) (pages, queries []analyticsEntry) {
+  a.mu.Lock()
+  defer a.mu.Unlock()
+  return a.top(a.Pages, days), a.top(a.Queries, days)
+}
+
+// prune drops days that no longer fit in any dashboard period.
+func (a *analytics) prune() {
+  oldest := time.Now().AddDate(0, 0, 1-analyticsKeepDays).Format(analyticsDayFormat)
+  for _, byDay := range []map[string]map[string]int{a.Pages, a.Queries} {
+    for day := range byDay {
+      if day < oldest {
+        delete(byDay, day)
+        a.dirty = true
+      }
+    }
+  }
+}
+
+// Save writes the counts to path if anything changed since the last
+// successful save.
+func (a *analytics) Save(path string) error {
+  if a == nil || path == "" {
+    return nil
+  }
+  a.mu.Lock()
+  a.prune()
+  if !a.dirty {
+    a.mu.Unlock()
+    return nil
+  }
+  data, err := json.Marshal(a)
+  a.dirty = false
+  a.mu.Unlock()
+  if err == nil {
+    err = writeFileAtomic(path, data)
+  }
+  if err != nil {
+    a.mu.Lock()
+    a.dirty = true
+    a.mu.Unlock()
+  }
+  return err
+}
+
+// saveAnalytics saves the counts to the configured file. It holds
+// configUpdateMu so the counts and the file always belong together, even
+// while /admin/config swaps them.
+func saveAnalytics() error {
+  configUpdateMu.Lock()
+  defer configUpdateMu.Unlock()
+  return usage.Load().Save(currentConfig().AnalyticsFile)
+}
+
+func saveAnalyticsPeriodically(interval time.Duration) {
+  for range time.Tick(interval) {
+    if err := saveAnalytics(); err != nil {
+      fmt.Println("Error saving analytics: ", err)
+    }
+  }
+}
+
+// resultLink is the link a result page for query uses for the document at
+// p: /view, which highlights the query, for pages, and /static/ for other
+// files or without a query. With analytics enabled it goes through /go,
+// which counts the click. The query is optional so custom templates
+// written before highlighting keep working.
+func resultLink(p string, q ...string) string {
+  query := ""
+  if len(q) > 0 {
+    query = q[0]
+  }
+  if usage.Load() != nil {
+    link := "/go?path=" + url.QueryEscape(p)
+    if query != "" {
+      link += "&q=" + url.QueryEscape(query)
+    }
+    return link
+  }
+  if query != "" && isHTMLPath(p) {
+    return viewLink(p, query)
+  }
+  return staticLinkEscaped(p)
+}
+
+// handleGo counts a click on a search result and redirects to the
+// document, highlighted when ?q= is given. Only documents that exist are
+// counted or redirected to, so the counts can't be filled with made-up
+// paths. They are looked up as /static/ does rather than in the index, as
+// searches also find pages added since it was built.
+func handleGo(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  file, _, err := resolveDocument(docsFS(currentConfig().Directory), r.URL.Query().Get("path"))
+  if err != nil {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_page")
+    return
+  }
+  p := logicalPath(file)
+  usage.Load().AddPage(p)
+  w.Header().Set("Cache-Control", "no-store")
+  if q := r.URL.Query().Get("q"); q != "" && isHTMLPath(p) {
+    http.Redirect(w, r, viewLink(p, q), http.StatusFound)
+    return
+  }
+  http.Redirect(w, r, staticLinkEscaped(p), http.StatusFound)
+}
+
+type dashboardPage struct {
+  pageData
+  Days int
+  Pages []analyticsEntry
+  Queries []analyticsEntry
+}
+
+var dashboardTemplate *template.Template
+
+// handleDashboard shows the top pages and queries over the last 7 days, or
+// 30 with ?days=30.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  a := usage.Load()
+  if a == nil {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "dashboard.disabled")
+    return
+  }
+  days := 7
+  if r.URL.Query().Get("days") == "30" {
+    days = 30
+  }
+  templatesMu.RLock()
+  tmpl := dashboardTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    http.Error(w, translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
+    return
+  }
+  lang := requestLanguage(r)
+  page := dashboardPage{pageData: newPageData(r, translate(lang, "dashboard.title"), ""), Days: days}
+  page.Pages, page.Queries = a.Top(days)
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.Header().Set("Cache-Control", "no-store")
+  if err := tmpl.Execute(w, page); err != nil {
+    fmt.Println("Error rendering dashboard: ", err)
+  }
+}
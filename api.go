@@ -0,0 +1,261 @@
+package main
+
+import (
+  "context"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strings"
+  "time"
+)
+
+const ndjsonFlushEvery = 10
+const ndjsonFlushInterval = 500 * time.Millisecond
+
+type searchSummary struct {
+  Summary bool `json:"summary"`
+  Total int `json:"total"`
+  ElapsedMS int64 `json:"elapsed_ms"`
+  Truncated bool `json:"truncated"`
+  Reason string `json:"reason,omitempty"`
+  Errors int `json:"errors,omitempty"`
+}
+
+type searchResponse struct {
+  Query string `json:"query"`
+  Results []SearchResult `json:"results"`
+  Total int `json:"total"`
+  ElapsedMS int64 `json:"elapsed_ms"`
+  Truncated bool `json:"truncated"`
+  Reason string `json:"reason,omitempty"`
+  Errors int `json:"errors,omitempty"`
+}
+
+func wantsNDJSON(r *http.Request) bool {
+  return r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+func searchContext(r *http.Request) (context.Context, context.CancelFunc) {
+  if timeout := currentConfig().SearchTimeoutSeconds; timeout > 0 {
+    return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+  }
+  return context.WithCancel(r.Context())
+}
+
+// handleAPISearch returns search results as a JSON object, or as one JSON
+// object per line followed by a summary line when NDJSON is requested.
+func handleAPISearch(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) || refuseWhileIndexing(w, r, true) {
+    return
+  }
+  query, err, status := validateSearchParams(r)
+  if err != nil {
+    writeJSONError(w, status, err.Error())
+    return
+  }
+
+  cfg := currentConfig()
+  ctx, cancel := searchContext(r)
+  defer cancel()
+  start := time.Now()
+  summary := searchSummary{Summary: true}
+  opts := searchOptionsFor(r)
+  opts.Stats = &scanStats{}
+  if all := r.URL.Query().Get("allmatches"); all == "1" || all == "true" {
+    opts.MaxMatches = cfg.MaxMatchesPerFile
+  }
+
+  // ?file= checks a single document and always lists its matches.
+  search := func(emit func(SearchResult) error) error {
+    return searchDocuments(ctx, docsFS(cfg.Directory), query, opts, emit)
+  }
+  if rel := r.URL.Query().Get("file"); rel != "" {
+    file, _, err := resolveDocument(docsFS(cfg.Directory), rel)
+    if err == errInvalidPath && isSearchable(rel) {
+      writeJSONError(w, http.StatusBadRequest, "invalid file path")
+      return
+    }
+    if err != nil {
+      writeJSONError(w, http.StatusNotFound, "file not found")
+      return
+    }
+    opts.MaxMatches = cfg.MaxMatchesPerFile
+    search = func(emit func(SearchResult) error) error {
+      return searchFileList(ctx, docsFS(cfg.Directory), []string{file}, query, opts, emit)
+    }
+  }
+
+  if wantsNDJSON(r) {
+    w.Header().Set("Content-Type", "application/x-ndjson")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    flusher, _ := w.(http.Flusher)
+    enc := json.NewEncoder(w)
+    lastFlush := time.Now()
+    err := search(func(result SearchResult) error {
+      if err := enc.Encode(result); err != nil {
+        return err
+      }
+      summary.Total++
+      if flusher != nil && (summary.Total%ndjsonFlushEvery == 0 || time.Since(lastFlush) > ndjsonFlushInterval) {
+        flusher.Flush()
+        lastFlush = time.Now()
+      }
+      if cfg.MaxResults > 0 && summary.Total >= cfg.MaxResults {
+        summary.Truncated = true
+        summary.Reason = "max_results"
+        return errStopSearch
+      }
+      return nil
+    })
+    if err != nil && r.Context().Err() != nil {
+      return
+    }
+    if err != nil {
+      summary.Truncated = true
+      summary.Reason = "error"
+      if ctx.Err() == context.DeadlineExceeded {
+        summary.Reason = "timeout"
+      } else {
+        logSearchError(w, err)
+      }
+    }
+    summary.Errors = opts.Stats.FileErrors
+    summary.ElapsedMS = time.Since(start).Milliseconds()
+    enc.Encode(summary)
+    return
+  }
+
+  results := []SearchResult{}
+  err = search(func(result SearchResult) error {
+    results = append(results, result)
+    if cfg.MaxResults > 0 && len(results) >= cfg.MaxResults {
+      summary.Truncated = true
+      summary.Reason = "max_results"
+      return errStopSearch
+    }
+    return nil
+  })
+  if err != nil {
+    if r.Context().Err() != nil {
+      return
+    }
+    if ctx.Err() != context.DeadlineExceeded {
+      id := logSearchError(w, err)
+      writeJSONError(w, http.StatusInternalServerError, "error searching files (request id "+id+")")
+      return
+    }
+    summary.Truncated = true
+    summary.Reason = "timeout"
+  }
+  sortByFields(results, opts.Sort)
+  recent.Load().Add(RecentQuery{Query: query, Time: time.Now(), Results: len(results), IP: clientIP(r)})
+  usage.Load().AddQuery(query)
+  writeJSON(w, http.StatusOK, searchResponse{
+    Query: query,
+    Results: results,
+    Total: len(results),
+    ElapsedMS: time.Since(start).Milliseconds(),
+    Truncated: summary.Truncated,
+    Reason: summary.Reason,
+    Errors: opts.Stats.FileErrors,
+  })
+}
+
+const maxBatchQueries = 50
+const maxBatchBodySize = 64 << 10
+
+// handleAPISearchBatch runs up to maxBatchQueries queries over a single pass
+// of the document set and returns a map from query to its results. Each
+// query is matched as /api/search would, with the options in the URL.
+func handleAPISearchBatch(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) || refuseWhileIndexing(w, r, true) {
+    return
+  }
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+    return
+  }
+
+  var batch []string
+  if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodySize)).Decode(&batch); err != nil {
+    writeJSONError(w, http.StatusBadRequest, "body must be a JSON array of query strings")
+    return
+  }
+  if len(batch) == 0 || len(batch) > maxBatchQueries {
+    writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("batch must contain between 1 and %d queries", maxBatchQueries))
+    return
+  }
+  if err, status := validateSearchOptions(r); err != nil {
+    writeJSONError(w, status, err.Error())
+    return
+  }
+  cfg := currentConfig()
+  opts := searchOptionsFor(r)
+  opts.Stats = &scanStats{}
+  if all := r.URL.Query().Get("allmatches"); all == "1" || all == "true" {
+    opts.MaxMatches = cfg.MaxMatchesPerFile
+  }
+  results := map[string][]SearchResult{}
+  needles := map[string]string{}
+  for _, q := range batch {
+    query, err, status := validateQuery(q)
+    if err != nil {
+      writeJSONError(w, status, fmt.Sprintf("invalid query %q: %v", q, err))
+      return
+    }
+    results[query] = []SearchResult{}
+    needles[query] = foldText(query, opts.Loose)
+  }
+
+  ctx, cancel := searchContext(r)
+  defer cancel()
+  start := time.Now()
+  summary := searchSummary{}
+  docs := currentIndex().Docs
+  fsys := docsFS(cfg.Directory)
+  files, err := searchFiles(ctx, fsys, searchPatterns)
+  if err == nil {
+    err = scanFiles(ctx, fsys, filterFiles(files, opts), opts.Stats, func(result SearchResult, text string) error {
+      folded := foldText(text, opts.Loose)
+      for query, needle := range needles {
+        if cfg.MaxResults > 0 && len(results[query]) >= cfg.MaxResults {
+          summary.Truncated = true
+          summary.Reason = "max_results"
+          continue
+        }
+        if result, ok := matchDocument(result, docs[result.Path], text, folded, query, needle, opts); ok {
+          results[query] = append(results[query], result)
+        }
+      }
+      return nil
+    })
+  }
+  if err != nil {
+    if r.Context().Err() != nil {
+      return
+    }
+    if ctx.Err() != context.DeadlineExceeded {
+      id := logSearchError(w, err)
+      writeJSONError(w, http.StatusInternalServerError, "error searching files (request id "+id+")")
+      return
+    }
+    summary.Truncated = true
+    summary.Reason = "timeout"
+  }
+  for _, list := range results {
+    sortByFields(list, opts.Sort)
+  }
+  response := map[string]interface{}{
+    "results": results,
+    "elapsed_ms": time.Since(start).Milliseconds(),
+    "truncated": summary.Truncated,
+  }
+  if summary.Reason != "" {
+    response["reason"] = summary.Reason
+  }
+  if opts.Stats.FileErrors > 0 {
+    response["errors"] = opts.Stats.FileErrors
+  }
+  writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+// post sends body to handler as a POST to target and returns the response.
+func post(handler http.HandlerFunc, target, body string) *httptest.ResponseRecorder {
+  w := httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(body)))
+  return w
+}
+
+func TestAPISearchBatch(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "it/printers.html": "<p>printer setup and vpn access</p>",
+    "it/vpn.html": "<p>vpn client</p>",
+    "hr/vacation.html": "<p>vacation policy</p>",
+  }, nil)
+
+  w := post(handleAPISearchBatch, "/api/search/batch", `["vpn", "printer", " vacation ", "nothing here"]`)
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  var resp struct {
+    Results map[string][]SearchResult `json:"results"`
+  }
+  if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+    t.Fatal(err)
+  }
+  counts := map[string]int{}
+  for query, results := range resp.Results {
+    counts[query] = len(results)
+  }
+  want := map[string]int{"vpn": 2, "printer": 1, "vacation": 1, "nothing here": 0}
+  for query, n := range want {
+    if counts[query] != n {
+      t.Errorf("%q: %d results, want %d", query, counts[query], n)
+    }
+  }
+  if len(counts) != len(want) {
+    t.Errorf("results for %v, want %v", counts, want)
+  }
+
+  for _, body := range []string{`[]`, `"vpn"`, `["vpn", "x"]`} {
+    if w := post(handleAPISearchBatch, "/api/search/batch", body); w.Code != http.StatusBadRequest {
+      t.Errorf("body %s: status = %d, want 400", body, w.Code)
+    }
+  }
+  if w := get(handleAPISearchBatch, "/api/search/batch"); w.Code != http.StatusMethodNotAllowed {
+    t.Errorf("GET: status = %d, want 405", w.Code)
+  }
+}
+
+func searchAPI(t *testing.T, target string) searchResponse {
+  t.Helper()
+  w := get(handleAPISearch, target)
+  if w.Code != http.StatusOK {
+    t.Fatalf("%s: status = %d: %s", target, w.Code, w.Body)
+  }
+  var resp searchResponse
+  if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+    t.Fatal(err)
+  }
+  return resp
+}
+
+func TestAPISearchAllMatches(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "vpn.html": "<p>The vpn client.</p><p>Start the VPN.</p><p>Stop the vpn when done.</p>",
+  }, func(c *Config) {
+    c.MaxMatchesPerFile = 2
+    c.ShowMatchCount = true
+  })
+
+  resp := searchAPI(t, "/api/search?q=vpn")
+  if len(resp.Results) != 1 || len(resp.Results[0].Matches) != 0 {
+    t.Fatalf("without allmatches: %+v", resp.Results)
+  }
+  resp = searchAPI(t, "/api/search?q=vpn&allmatches=1")
+  matches := resp.Results[0].Matches
+  if len(matches) != 2 {
+    t.Fatalf("allmatches listed %d matches, want maxMatchesPerFile = 2", len(matches))
+  }
+  if matches[0].Offset >= matches[1].Offset {
+    t.Errorf("offsets %d, %d are not increasing", matches[0].Offset, matches[1].Offset)
+  }
+  if resp.Results[0].MatchCount != 3 {
+    t.Errorf("match_count = %d, want all 3", resp.Results[0].MatchCount)
+  }
+
+  useConfig(t, func(c *Config) {
+    c.Directory = currentConfig().Directory
+    c.MaxMatchesPerFile = 20
+  })
+  resp = searchAPI(t, "/api/search?q=vpn&allmatches=true")
+  if n := len(resp.Results[0].Matches); n != 3 {
+    t.Errorf("allmatches listed %d matches, want 3", n)
+  }
+  if resp.Results[0].MatchCount != 0 {
+    t.Errorf("match_count = %d without showMatchCount", resp.Results[0].MatchCount)
+  }
+}
+
+func TestAPISearchSingleFile(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "it/vpn.html": "<p>vpn client</p><p>vpn server</p>",
+    "it/printers.html": "<p>printer, no vpn here? yes, vpn</p>",
+  }, nil)
+
+  resp := searchAPI(t, "/api/search?q=vpn&file=it/vpn.html")
+  if len(resp.Results) != 1 || resp.Results[0].Path != "it/vpn.html" {
+    t.Fatalf("results = %+v, want it/vpn.html alone", resp.Results)
+  }
+  if n := len(resp.Results[0].Matches); n != 2 {
+    t.Errorf("%d matches listed, want 2", n)
+  }
+  if resp := searchAPI(t, "/api/search?q=printer&file=it/vpn.html"); len(resp.Results) != 0 {
+    t.Errorf("a query the file lacks found %+v", resp.Results)
+  }
+  for target, status := range map[string]int{
+    "/api/search?q=vpn&file=it/missing.html": http.StatusNotFound,
+    "/api/search?q=vpn&file=../it/vpn.html": http.StatusBadRequest,
+    "/api/search?q=vpn&file=it/../../vpn.html": http.StatusBadRequest,
+  } {
+    if w := get(handleAPISearch, target); w.Code != status {
+      t.Errorf("%s: status = %d, want %d", target, w.Code, status)
+    }
+  }
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "io"
+  "io/fs"
+  "net/http"
+  "os"
+  "path"
+  "github.com/Albatrosicks/temp-wika/assets"
+)
+
+// overlayFS serves files from dir when present there and from the embedded
+// assets otherwise, so single files can be customized without rebuilding.
+type overlayFS struct {
+  dir string
+  embedded fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+  if o.dir != "" {
+    if f, err := os.DirFS(o.dir).Open(name); err == nil {
+      return f, nil
+    }
+  }
+  return o.embedded.Open(name)
+}
+
+func assetFS() fs.FS {
+  return overlayFS{dir: currentConfig().AssetsDir, embedded: assets.FS}
+}
+
+func readAsset(name string) ([]byte, error) {
+  return fs.ReadFile(assetFS(), name)
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, name string) {
+  f, err := assetFS().Open(name)
+  if err != nil {
+    http.NotFound(w, r)
+    return
+  }
+  defer f.Close()
+  info, err := f.Stat()
+  if err != nil {
+    http.Error(w, translate(requestLanguage(r), "error.reading_file"), http.StatusInternalServerError)
+    return
+  }
+  content, ok := f.(io.ReadSeeker)
+  if !ok {
+    http.Error(w, translate(requestLanguage(r), "error.reading_file"), http.StatusInternalServerError)
+    return
+  }
+  http.ServeContent(w, r, path.Base(name), info.ModTime(), content)
+}
+
+// minimalSearchForm is served when search.html cannot be loaded from either
+// the override directory or the embedded assets.
+const minimalSearchForm = `<!DOCTYPE html>
+<html lang="%s">
+<head><title>%s</title><link rel="stylesheet" href="/style.css"></link></head>
+<body>
+  <form action="/" method="get">
+    <input type="text" name="q" placeholder="%s">
+    <input type="submit" value="%s">
+  </form>
+</body>
+</html>
+`
+
+// pageData is what the search form and error templates render with. Lang
+// selects the message catalog used by the t template function. It
+// carries the same Query, View, Dir, Ext, Tag, Count and Searches fields
+// as resultsPage so both can include the shared header.
+type pageData struct {
+  Lang string
+  SiteTitle string
+  LogoURL string
+  Title string
+  Message string
+  Query string
+  View string
+  Dir string
+  Ext string
+  Tag string
+  Sort string
+  In string
+  Count int
+  Searches []string
+  // Suggestion is a query to try instead when nothing was found, run by
+  // SuggestionURL.
+  Suggestion string
+  SuggestionURL string
+}
+
+func newPageData(r *http.Request, title, message string) pageData {
+  cfg := currentConfig()
+  dir, ext := searchFilters(r)
+  return pageData{
+    Lang: requestLanguage(r),
+    SiteTitle: cfg.SiteTitle,
+    LogoURL: logoURL(cfg),
+    Title: title,
+    Message: message,
+    Query: r.URL.Query().Get("q"),
+    View: resultsView(r),
+    Dir: dir,
+    Ext: ext,
+    Tag: normalizeTag(r.URL.Query().Get("tag")),
+    Sort: r.URL.Query().Get("sort"),
+    In: r.URL.Query().Get("in"),
+    Searches: recentSearches(r),
+  }
+}
+
+var (
+  searchFormTemplate *template.Template
+  errorTemplate *template.Template
+)
+
+// withHeader adds the shared "header" template from header.html to tmpl,
+// falling back to the embedded copy if the override does not parse.
+func withHeader(tmpl *template.Template) (*template.Template, error) {
+  text, err := readAsset("header.html")
+  if err == nil {
+    _, err = tmpl.New("header.html").Parse(string(text))
+  }
+  if err != nil {
+    fmt.Println("Error loading header.html, using the built-in one: ", err)
+    text, _ = assets.FS.ReadFile("header.html")
+    _, err = tmpl.New("header.html").Parse(string(text))
+  }
+  return tmpl, err
+}
+
+func parseAsset(name string) *template.Template {
+  text, err := readAsset(name)
+  if err == nil {
+    var tmpl *template.Template
+    tmpl, err = withHeader(template.New(name).Funcs(templateFuncs))
+    if err == nil {
+      tmpl, err = tmpl.Parse(string(text))
+    }
+    if err == nil {
+      return tmpl
+    }
+  }
+  fmt.Println("Error loading template", name, ":", err)
+  return nil
+}
+
+func loadPageTemplates() {
+  searchForm := parseAsset("search.html")
+  errorPage := parseAsset("error.html")
+  browse := parseAsset("browse.html")
+  landing := parseAsset("landing.html")
+  dashboard := parseAsset("dashboard.html")
+  titles := parseAsset("titles.html")
+  stream := parseAsset("stream.html")
+  tags := parseAsset("tags.html")
+  templatesMu.Lock()
+  searchFormTemplate = searchForm
+  errorTemplate = errorPage
+  browseTemplate = browse
+  landingTemplate = landing
+  dashboardTemplate = dashboard
+  titlesTemplate = titles
+  streamTemplate = stream
+  tagsTemplate = tags
+  templatesMu.Unlock()
+}
+
+// serveSearchForm serves the landing page with recently updated documents,
+// or search.html when staticSearchForm is set, assetsDir overrides it, or
+// the landing template is unavailable.
+func serveSearchForm(w http.ResponseWriter, r *http.Request) {
+  templatesMu.RLock()
+  tmpl := searchFormTemplate
+  landing := landingTemplate
+  templatesMu.RUnlock()
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if landing != nil && !currentConfig().StaticSearchForm && !customSearchForm() {
+    if err := landing.Execute(w, landingPage{pageData: newPageData(r, "", ""), Recent: recentlyUpdated()}); err != nil {
+      fmt.Println("Error rendering landing page: ", err)
+    }
+    return
+  }
+  if tmpl == nil {
+    lang := requestLanguage(r)
+    fmt.Fprintf(w, minimalSearchForm, lang, template.HTMLEscapeString(translate(lang, "search.title")), template.HTMLEscapeString(translate(lang, "search.placeholder")), template.HTMLEscapeString(translate(lang, "search.submit")))
+    return
+  }
+  if err := tmpl.Execute(w, newPageData(r, "", "")); err != nil {
+    fmt.Println("Error rendering search form: ", err)
+  }
+}
+
+// renderError shows the error page. title and message are catalog keys,
+// with args formatted into the message.
+func renderError(w http.ResponseWriter, r *http.Request, status int, title, message string, args ...interface{}) {
+  lang := requestLanguage(r)
+  renderErrorPage(w, r, status, newPageData(r, translate(lang, title), translate(lang, message, args...)))
+}
+
+// renderErrorPage shows the error page for page, already translated.
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, page pageData) {
+  templatesMu.RLock()
+  tmpl := errorTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    http.Error(w, page.Message, status)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.WriteHeader(status)
+  tmpl.Execute(w, page)
+}
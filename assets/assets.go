@@ -0,0 +1,13 @@
+// Package assets holds the first-party UI files compiled into the binary.
+package assets
+
+import "embed"
+
+//go:embed search.html style.css results.html error.html header.html browse.html landing.html dashboard.html titles.html stream.html tags.html favicon.ico i18n
+var FS embed.FS
+
+// Sample is a handful of pages served when no document directory is
+// configured, so the binary can be tried out on its own.
+//
+//go:embed sample
+var Sample embed.FS
@@ -0,0 +1,151 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "io/fs"
+  "net/http"
+  "os"
+  "path"
+  "sort"
+  "strings"
+  "time"
+)
+
+type browseEntry struct {
+  Name string
+  URL string
+  Title string
+  Size string
+  Modified time.Time
+}
+
+type breadcrumb struct {
+  Name string
+  URL string
+}
+
+type browsePage struct {
+  pageData
+  Crumbs []breadcrumb
+  Dirs []browseEntry
+  Files []browseEntry
+}
+
+var browseTemplate *template.Template
+
+func browseLink(rel string) string {
+  if rel == "" {
+    return "/browse/"
+  }
+  return "/browse/" + rel + "/"
+}
+
+// browsePath validates the part of the URL after /browse/. Hidden path
+// segments and anything that is not already in clean form are rejected, so
+// the result can never point outside the wiki directory.
+func browsePath(p string) (string, bool) {
+  rel := strings.Trim(p, "/")
+  if strings.Contains(rel, "\\") || strings.Contains(rel, "\x00") {
+    return "", false
+  }
+  if rel == "" {
+    return "", true
+  }
+  if path.Clean("/"+rel) != "/"+rel {
+    return "", false
+  }
+  for _, segment := range strings.Split(rel, "/") {
+    if strings.HasPrefix(segment, ".") {
+      return "", false
+    }
+  }
+  return rel, true
+}
+
+// handleBrowse lists the subdirectories and documents of one directory of
+// the wiki, linking documents to /static/.
+func handleBrowse(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  rel, ok := browsePath(strings.TrimPrefix(r.URL.Path, "/browse"))
+  if !ok {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_folder")
+    return
+  }
+  if r.URL.Path != browseLink(rel) {
+    http.Redirect(w, r, browseLink(rel), http.StatusMovedPermanently)
+    return
+  }
+
+  dir := rel
+  if dir == "" {
+    dir = "."
+  }
+  entries, err := fs.ReadDir(docsFS(currentConfig().Directory), dir)
+  if err != nil {
+    if !os.IsNotExist(err) {
+      fmt.Println("Error reading directory", rel, ":", err)
+    }
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_folder")
+    return
+  }
+
+  lang := requestLanguage(r)
+  rootTitle := translate(lang, "browse.root")
+  title := rootTitle
+  if rel != "" {
+    title = path.Base(rel)
+  }
+  page := browsePage{pageData: newPageData(r, title, "")}
+  page.Crumbs = append(page.Crumbs, breadcrumb{Name: rootTitle, URL: browseLink("")})
+  if rel != "" {
+    parts := strings.Split(rel, "/")
+    for i, part := range parts {
+      page.Crumbs = append(page.Crumbs, breadcrumb{Name: part, URL: browseLink(strings.Join(parts[:i+1], "/"))})
+    }
+  }
+
+  docs := currentIndex().Docs
+  for _, entry := range entries {
+    name := entry.Name()
+    if strings.HasPrefix(name, ".") {
+      continue
+    }
+    info, err := entry.Info()
+    if err != nil {
+      continue
+    }
+    if entry.IsDir() {
+      page.Dirs = append(page.Dirs, browseEntry{Name: name, URL: browseLink(path.Join(rel, name)), Modified: info.ModTime()})
+      continue
+    }
+    if !isSearchable(name) {
+      continue
+    }
+    p := logicalPath(path.Join(rel, name))
+    item := browseEntry{Name: path.Base(p), URL: staticLinkEscaped(p), Size: formatSize(lang, info.Size()), Modified: info.ModTime()}
+    if doc, ok := docs[p]; ok {
+      item.Title = doc.Title
+    }
+    page.Files = append(page.Files, item)
+  }
+  byName := func(list []browseEntry) {
+    sort.Slice(list, func(i, j int) bool { return strings.ToLower(list[i].Name) < strings.ToLower(list[j].Name) })
+  }
+  byName(page.Dirs)
+  byName(page.Files)
+
+  templatesMu.RLock()
+  tmpl := browseTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    http.Error(w, translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if err := tmpl.Execute(w, page); err != nil {
+    fmt.Println("Error generating directory listing: ", err)
+  }
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+  "fmt"
+  "io/fs"
+  "strings"
+  "sync/atomic"
+  "time"
+  lru "github.com/hashicorp/golang-lru/v2"
+)
+
+type cachedText struct {
+  modified time.Time
+  size int64
+  text string
+}
+
+// textCache keeps the extracted text of recently searched files, keyed by
+// their path in the documents filesystem. Entries are only used while the
+// file's mtime and size match.
+type textCache struct {
+  entries *lru.Cache[string, cachedText]
+}
+
+// texts is swapped when /admin/config changes its size.
+var texts atomic.Pointer[textCache]
+
+// textCacheHits and textCacheMisses count lookups in the text cache, for
+// /admin/stats.
+var textCacheHits, textCacheMisses atomic.Int64
+
+func newTextCache(size int) *textCache {
+  if size <= 0 {
+    return nil
+  }
+  entries, err := lru.New[string, cachedText](size)
+  if err != nil {
+    return nil
+  }
+  return &textCache{entries: entries}
+}
+
+func (c *textCache) Get(file string, info fs.FileInfo) (string, bool) {
+  if c == nil {
+    return "", false
+  }
+  entry, ok := c.entries.Get(file)
+  if !ok {
+    textCacheMisses.Add(1)
+    return "", false
+  }
+  if !entry.modified.Equal(info.ModTime()) || entry.size != info.Size() {
+    c.entries.Remove(file)
+    textCacheMisses.Add(1)
+    return "", false
+  }
+  textCacheHits.Add(1)
+  return entry.text, true
+}
+
+func (c *textCache) Len() int {
+  if c == nil {
+    return 0
+  }
+  return c.entries.Len()
+}
+
+func (c *textCache) Add(file string, info fs.FileInfo, text string) {
+  if c == nil {
+    return
+  }
+  c.entries.Add(file, cachedText{modified: info.ModTime(), size: info.Size(), text: text})
+}
+
+// CachedResult is a finished search, usable until Expires.
+type CachedResult struct {
+  Results []SearchResult
+  Expires time.Time
+}
+
+// QueryCache keeps the results of recent searches for a short while, so
+// repeated queries don't walk the directory again. It holds at most a fixed
+// number of entries, evicting the least recently used.
+type QueryCache struct {
+  entries *lru.Cache[string, *CachedResult]
+  ttl time.Duration
+}
+
+// queries is swapped when /admin/config changes its size or TTL.
+var queries atomic.Pointer[QueryCache]
+
+// queryCacheHits and queryCacheMisses count lookups in the query cache, for
+// /admin/stats.
+var queryCacheHits, queryCacheMisses atomic.Int64
+
+func newQueryCache(size int, ttl time.Duration) *QueryCache {
+  if size <= 0 || ttl <= 0 {
+    return nil
+  }
+  entries, err := lru.New[string, *CachedResult](size)
+  if err != nil {
+    return nil
+  }
+  return &QueryCache{entries: entries, ttl: ttl}
+}
+
+// queryCacheKey is the lowercased query plus every option that changes
+// which results it finds.
+func queryCacheKey(query string, opts searchOptions) string {
+  return fmt.Sprintf("%s\x00%t\x00%s\x00%d\x00%t\x00%t\x00%s\x00%s\x00%s", strings.ToLower(query), opts.Loose, opts.In, opts.Since.UnixNano(), opts.Score, opts.Fuzzy, opts.Dir, opts.Ext, opts.Tag)
+}
+
+// Get returns a copy of the cached results, which callers may reorder.
+func (c *QueryCache) Get(key string) ([]SearchResult, bool) {
+  if c == nil {
+    return nil, false
+  }
+  entry, ok := c.entries.Get(key)
+  if ok && time.Now().After(entry.Expires) {
+    c.entries.Remove(key)
+    ok = false
+  }
+  if !ok {
+    queryCacheMisses.Add(1)
+    return nil, false
+  }
+  queryCacheHits.Add(1)
+  return append([]SearchResult(nil), entry.Results...), true
+}
+
+func (c *QueryCache) Add(key string, results []SearchResult) {
+  if c == nil {
+    return
+  }
+  c.entries.Add(key, &CachedResult{Results: append([]SearchResult(nil), results...), Expires: time.Now().Add(c.ttl)})
+}
+
+// Purge drops every entry, for when the index changes.
+func (c *QueryCache) Purge() {
+  if c != nil {
+    c.entries.Purge()
+  }
+}
+
+func (c *QueryCache) Len() int {
+  if c == nil {
+    return 0
+  }
+  return c.entries.Len()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+  "io/fs"
+  "testing"
+  "testing/fstest"
+  "time"
+)
+
+func stat(t *testing.T, docs fstest.MapFS, name string) fs.FileInfo {
+  t.Helper()
+  info, err := docs.Stat(name)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return info
+}
+
+func TestTextCacheEvictsLeastRecentlyUsed(t *testing.T) {
+  modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+  docs := fstest.MapFS{
+    "a.html": {Data: []byte("a"), ModTime: modified},
+    "b.html": {Data: []byte("b"), ModTime: modified},
+    "c.html": {Data: []byte("c"), ModTime: modified},
+  }
+  cache := newTextCache(2)
+  for _, name := range []string{"a.html", "b.html"} {
+    cache.Add(name, stat(t, docs, name), "text of "+name)
+  }
+  // a is now the most recently used, so c pushes out b.
+  if text, ok := cache.Get("a.html", stat(t, docs, "a.html")); !ok || text != "text of a.html" {
+    t.Fatalf("a.html = %q, %v", text, ok)
+  }
+  cache.Add("c.html", stat(t, docs, "c.html"), "text of c.html")
+  if _, ok := cache.Get("b.html", stat(t, docs, "b.html")); ok {
+    t.Error("b.html was not evicted")
+  }
+  for _, name := range []string{"a.html", "c.html"} {
+    if _, ok := cache.Get(name, stat(t, docs, name)); !ok {
+      t.Errorf("%s was evicted", name)
+    }
+  }
+  if cache.Len() != 2 {
+    t.Errorf("Len = %d, want 2", cache.Len())
+  }
+}
+
+func TestTextCacheInvalidatesChangedFiles(t *testing.T) {
+  modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+  docs := fstest.MapFS{"a.html": {Data: []byte("old"), ModTime: modified}}
+  cache := newTextCache(10)
+  cache.Add("a.html", stat(t, docs, "a.html"), "old text")
+
+  docs["a.html"] = &fstest.MapFile{Data: []byte("new"), ModTime: modified.Add(time.Second)}
+  if _, ok := cache.Get("a.html", stat(t, docs, "a.html")); ok {
+    t.Error("entry used after the mtime changed")
+  }
+  if cache.Len() != 0 {
+    t.Error("stale entry was not removed")
+  }
+
+  cache.Add("a.html", stat(t, docs, "a.html"), "new text")
+  docs["a.html"] = &fstest.MapFile{Data: []byte("newer"), ModTime: modified.Add(time.Second)}
+  if _, ok := cache.Get("a.html", stat(t, docs, "a.html")); ok {
+    t.Error("entry used after the size changed")
+  }
+}
+
+func TestTextCacheDisabled(t *testing.T) {
+  cache := newTextCache(0)
+  if cache != nil {
+    t.Fatal("textCacheSize 0 made a cache")
+  }
+  info := stat(t, fstest.MapFS{"a.html": {Data: []byte("a")}}, "a.html")
+  cache.Add("a.html", info, "a")
+  if _, ok := cache.Get("a.html", info); ok || cache.Len() != 0 {
+    t.Error("a nil cache returned an entry")
+  }
+}
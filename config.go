@@ -0,0 +1,410 @@
+package main
+
+import (
+  "bytes"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync"
+  "gopkg.in/yaml.v3"
+  "github.com/BurntSushi/toml"
+)
+
+// Config keys are lowerCamelCase with omitempty. encoding/json matches keys
+// case-insensitively, so older files using "IPRanges" still load; YAML and
+// TOML keys must match exactly.
+type Config struct {
+  Port string `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty"`
+  IPRanges []IPRange `json:"ipRanges,omitempty" yaml:"ipRanges,omitempty" toml:"ipRanges,omitempty"`
+  Directory string `json:"directory,omitempty" yaml:"directory,omitempty" toml:"directory,omitempty"`
+  Templates map[string]string `json:"templates,omitempty" yaml:"templates,omitempty" toml:"templates,omitempty"`
+  MaxFileSize int64 `json:"maxFileSize,omitempty" yaml:"maxFileSize,omitempty" toml:"maxFileSize,omitempty"`
+  BasicAuth BasicAuth `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty" toml:"basicAuth,omitempty"`
+  RecentQueriesSize int `json:"recentQueriesSize,omitempty" yaml:"recentQueriesSize,omitempty" toml:"recentQueriesSize,omitempty"`
+  DisableRecentQueries bool `json:"disableRecentQueries,omitempty" yaml:"disableRecentQueries,omitempty" toml:"disableRecentQueries,omitempty"`
+  ReindexIntervalSeconds int `json:"reindexIntervalSeconds,omitempty" yaml:"reindexIntervalSeconds,omitempty" toml:"reindexIntervalSeconds,omitempty"`
+  WebhookURL string `json:"webhookURL,omitempty" yaml:"webhookURL,omitempty" toml:"webhookURL,omitempty"`
+  WebhookSecret string `json:"webhookSecret,omitempty" yaml:"webhookSecret,omitempty" toml:"webhookSecret,omitempty"`
+  MinQueryLength int `json:"minQueryLength,omitempty" yaml:"minQueryLength,omitempty" toml:"minQueryLength,omitempty"`
+  MaxQueryLength int `json:"maxQueryLength,omitempty" yaml:"maxQueryLength,omitempty" toml:"maxQueryLength,omitempty"`
+  AssetsDir string `json:"assetsDir,omitempty" yaml:"assetsDir,omitempty" toml:"assetsDir,omitempty"`
+  AllowEphemeralPort bool `json:"allowEphemeralPort,omitempty" yaml:"allowEphemeralPort,omitempty" toml:"allowEphemeralPort,omitempty"`
+  ListenAddr string `json:"listenAddr,omitempty" yaml:"listenAddr,omitempty" toml:"listenAddr,omitempty"`
+  SocketMode string `json:"socketMode,omitempty" yaml:"socketMode,omitempty" toml:"socketMode,omitempty"`
+  AllowUnknownPeer bool `json:"allowUnknownPeer,omitempty" yaml:"allowUnknownPeer,omitempty" toml:"allowUnknownPeer,omitempty"`
+  MaxResults int `json:"maxResults,omitempty" yaml:"maxResults,omitempty" toml:"maxResults,omitempty"`
+  SearchTimeoutSeconds int `json:"searchTimeoutSeconds,omitempty" yaml:"searchTimeoutSeconds,omitempty" toml:"searchTimeoutSeconds,omitempty"`
+  SiteTitle string `json:"siteTitle,omitempty" yaml:"siteTitle,omitempty" toml:"siteTitle,omitempty"`
+  LogoURL string `json:"logoURL,omitempty" yaml:"logoURL,omitempty" toml:"logoURL,omitempty"`
+  LogoPath string `json:"logoPath,omitempty" yaml:"logoPath,omitempty" toml:"logoPath,omitempty"`
+  CORSOrigins []string `json:"corsOrigins,omitempty" yaml:"corsOrigins,omitempty" toml:"corsOrigins,omitempty"`
+  TreeOrder string `json:"treeOrder,omitempty" yaml:"treeOrder,omitempty" toml:"treeOrder,omitempty"`
+  MaxMatchesPerFile int `json:"maxMatchesPerFile,omitempty" yaml:"maxMatchesPerFile,omitempty" toml:"maxMatchesPerFile,omitempty"`
+  TextCacheSize int `json:"textCacheSize,omitempty" yaml:"textCacheSize,omitempty" toml:"textCacheSize,omitempty"`
+  Language string `json:"language,omitempty" yaml:"language,omitempty" toml:"language,omitempty"`
+  AutoDetectLanguage bool `json:"autoDetectLanguage,omitempty" yaml:"autoDetectLanguage,omitempty" toml:"autoDetectLanguage,omitempty"`
+  HideFileSizes bool `json:"hideFileSizes,omitempty" yaml:"hideFileSizes,omitempty" toml:"hideFileSizes,omitempty"`
+  LooseMatch bool `json:"looseMatch,omitempty" yaml:"looseMatch,omitempty" toml:"looseMatch,omitempty"`
+  StaticMaxAgeSeconds int `json:"staticMaxAgeSeconds,omitempty" yaml:"staticMaxAgeSeconds,omitempty" toml:"staticMaxAgeSeconds,omitempty"`
+  StaticSearchForm bool `json:"staticSearchForm,omitempty" yaml:"staticSearchForm,omitempty" toml:"staticSearchForm,omitempty"`
+  OnFileError string `json:"onFileError,omitempty" yaml:"onFileError,omitempty" toml:"onFileError,omitempty"`
+  DisableAnalytics bool `json:"disableAnalytics,omitempty" yaml:"disableAnalytics,omitempty" toml:"disableAnalytics,omitempty"`
+  AnalyticsFile string `json:"analyticsFile,omitempty" yaml:"analyticsFile,omitempty" toml:"analyticsFile,omitempty"`
+  DisableIPCheck bool `json:"disableIPCheck,omitempty" yaml:"disableIPCheck,omitempty" toml:"disableIPCheck,omitempty"`
+  SearchCacheTTLSeconds int `json:"searchCacheTTLSeconds,omitempty" yaml:"searchCacheTTLSeconds,omitempty" toml:"searchCacheTTLSeconds,omitempty"`
+  SearchCacheMaxEntries int `json:"searchCacheMaxEntries,omitempty" yaml:"searchCacheMaxEntries,omitempty" toml:"searchCacheMaxEntries,omitempty"`
+  OpenSearchShortName string `json:"openSearchShortName,omitempty" yaml:"openSearchShortName,omitempty" toml:"openSearchShortName,omitempty"`
+  OpenSearchDescription string `json:"openSearchDescription,omitempty" yaml:"openSearchDescription,omitempty" toml:"openSearchDescription,omitempty"`
+  SnippetEllipsis string `json:"snippetEllipsis,omitempty" yaml:"snippetEllipsis,omitempty" toml:"snippetEllipsis,omitempty"`
+  ShowMatchCount bool `json:"showMatchCount,omitempty" yaml:"showMatchCount,omitempty" toml:"showMatchCount,omitempty"`
+  Features map[string]bool `json:"features,omitempty" yaml:"features,omitempty" toml:"features,omitempty"`
+  ShowTOC bool `json:"showTOC,omitempty" yaml:"showTOC,omitempty" toml:"showTOC,omitempty"`
+  DisableSearchHistory bool `json:"disableSearchHistory,omitempty" yaml:"disableSearchHistory,omitempty" toml:"disableSearchHistory,omitempty"`
+  CookieSecret string `json:"cookieSecret,omitempty" yaml:"cookieSecret,omitempty" toml:"cookieSecret,omitempty"`
+  Mounts []Mount `json:"mounts,omitempty" yaml:"mounts,omitempty" toml:"mounts,omitempty"`
+  TagMinCount int `json:"tagMinCount,omitempty" yaml:"tagMinCount,omitempty" toml:"tagMinCount,omitempty"`
+}
+
+// IPRange is an allowed CIDR with an optional friendly name for logs. In
+// config files it may be written as a plain CIDR string or as an object.
+type IPRange struct {
+  CIDR string `json:"cidr" yaml:"cidr" toml:"cidr"`
+  Name string `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+}
+
+func (r *IPRange) UnmarshalJSON(data []byte) error {
+  var cidr string
+  if err := json.Unmarshal(data, &cidr); err == nil {
+    *r = IPRange{CIDR: cidr}
+    return nil
+  }
+  type plain IPRange
+  var p plain
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(&p); err != nil {
+    return fmt.Errorf("IP range must be a CIDR string or {\"cidr\", \"name\"} object: %v", err)
+  }
+  *r = IPRange(p)
+  return nil
+}
+
+func (r *IPRange) UnmarshalYAML(value *yaml.Node) error {
+  if value.Kind == yaml.ScalarNode {
+    *r = IPRange{CIDR: value.Value}
+    return nil
+  }
+  if value.Kind == yaml.MappingNode {
+    for i := 0; i < len(value.Content); i += 2 {
+      if key := value.Content[i].Value; key != "cidr" && key != "name" {
+        return fmt.Errorf("line %d: unknown IP range field %q", value.Content[i].Line, key)
+      }
+    }
+  }
+  type plain IPRange
+  var p plain
+  if err := value.Decode(&p); err != nil {
+    return fmt.Errorf("IP range must be a CIDR string or {cidr, name} mapping: %v", err)
+  }
+  *r = IPRange(p)
+  return nil
+}
+
+// UnmarshalTOML receives either a string or an inline table.
+func (r *IPRange) UnmarshalTOML(value interface{}) error {
+  switch v := value.(type) {
+  case string:
+    *r = IPRange{CIDR: v}
+    return nil
+  case map[string]interface{}:
+    *r = IPRange{}
+    for key, field := range v {
+      s, ok := field.(string)
+      switch {
+      case key == "cidr" && ok:
+        r.CIDR = s
+      case key == "name" && ok:
+        r.Name = s
+      default:
+        return fmt.Errorf("IP range must be a CIDR string or {cidr, name} table, got field %q", key)
+      }
+    }
+    return nil
+  }
+  return fmt.Errorf("IP range must be a CIDR string or {cidr, name} table")
+}
+
+// MarshalJSON writes unnamed ranges back as plain strings.
+func (r IPRange) MarshalJSON() ([]byte, error) {
+  if r.Name == "" {
+    return json.Marshal(r.CIDR)
+  }
+  type plain IPRange
+  return json.Marshal(plain(r))
+}
+
+func (r IPRange) Label() string {
+  if r.Name != "" {
+    return r.Name
+  }
+  return r.CIDR
+}
+
+// BasicAuth protects the admin endpoints. HashedPassword is the hex-encoded
+// SHA-256 of the password.
+type BasicAuth struct {
+  Username string `json:"username,omitempty" yaml:"username,omitempty" toml:"username,omitempty"`
+  HashedPassword string `json:"hashedPassword,omitempty" yaml:"hashedPassword,omitempty" toml:"hashedPassword,omitempty"`
+}
+
+var (
+  configMu sync.RWMutex
+  config = &Config{}
+)
+
+// configPath is the file the config was loaded from, and where updates
+// through /admin/config are saved.
+var configPath = "config.json"
+
+// currentConfig returns the config in effect. The returned value is shared
+// between goroutines and must not be modified; use setConfig to replace it.
+func currentConfig() *Config {
+  configMu.RLock()
+  defer configMu.RUnlock()
+  return config
+}
+
+func setConfig(c *Config) {
+  configMu.Lock()
+  config = c
+  configMu.Unlock()
+}
+
+func defaultConfig() Config {
+  return Config{
+    Port: "8080",
+    MaxFileSize: 10 << 20,
+    RecentQueriesSize: 100,
+    MinQueryLength: 2,
+    MaxQueryLength: 256,
+    MaxResults: 1000,
+    SearchTimeoutSeconds: 30,
+    TreeOrder: treeOrderDirsFirst,
+    MaxMatchesPerFile: 20,
+    TextCacheSize: 1000,
+    Language: defaultLanguage,
+    StaticMaxAgeSeconds: 300,
+    OnFileError: onFileErrorSkip,
+    AnalyticsFile: "analytics.json",
+    SearchCacheTTLSeconds: 60,
+    SearchCacheMaxEntries: 100,
+    SnippetEllipsis: "…",
+    TagMinCount: 2,
+  }
+}
+
+// loadConfig reads the config file at path over the defaults, as YAML when
+// it ends in .yaml or .yml, as TOML for .toml and as JSON otherwise. A missing file leaves the
+// defaults in place; unknown or repeated keys are errors, so typos don't go
+// unnoticed.
+func loadConfig(path string) (Config, error) {
+  cfg := defaultConfig()
+  data, err := os.ReadFile(path)
+  if os.IsNotExist(err) {
+    fmt.Println("No", path, "found, using defaults")
+    return cfg, nil
+  }
+  if err != nil {
+    return cfg, err
+  }
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".yaml", ".yml":
+    // yaml.v3 rejects repeated keys itself.
+    dec := yaml.NewDecoder(bytes.NewReader(data))
+    dec.KnownFields(true)
+    if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+      return cfg, fmt.Errorf("%s: %v", path, err)
+    }
+    return cfg, nil
+  case ".toml":
+    // Parse errors carry their line number. TOML forbids repeated keys.
+    md, err := toml.NewDecoder(bytes.NewReader(data)).Decode(&cfg)
+    if err != nil {
+      return cfg, fmt.Errorf("%s: %v", path, err)
+    }
+    for _, key := range md.Undecoded() {
+      // IPRange.UnmarshalTOML checks the fields of its own tables.
+      if key[0] != "ipRanges" {
+        return cfg, fmt.Errorf("%s: unknown key %q", path, key.String())
+      }
+    }
+    return cfg, nil
+  }
+  if err := checkDuplicateKeys(data); err != nil {
+    return cfg, err
+  }
+  dec := json.NewDecoder(bytes.NewReader(data))
+  dec.DisallowUnknownFields()
+  if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+    return cfg, fmt.Errorf("%s: %v", path, err)
+  }
+  return cfg, nil
+}
+
+// checkDuplicateKeys rejects top-level keys given more than once. Keys are
+// compared case-insensitively, as encoding/json matches them.
+func checkDuplicateKeys(data []byte) error {
+  dec := json.NewDecoder(bytes.NewReader(data))
+  if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+    return nil // let Decode report the syntax error
+  }
+  seen := map[string]bool{}
+  for dec.More() {
+    t, err := dec.Token()
+    if err != nil {
+      return nil
+    }
+    key, _ := t.(string)
+    if seen[strings.ToLower(key)] {
+      return fmt.Errorf("duplicate config key %q", key)
+    }
+    seen[strings.ToLower(key)] = true
+    var skip json.RawMessage
+    if err := dec.Decode(&skip); err != nil {
+      return nil
+    }
+  }
+  return nil
+}
+
+const (
+  onFileErrorSkip = "skip"
+  onFileErrorFail = "fail"
+)
+
+func validateConfig(c Config) error {
+  port, err := strconv.Atoi(c.Port)
+  if err != nil || port < 0 || port > 65535 || (port == 0 && !c.AllowEphemeralPort) {
+    return fmt.Errorf("port must be a number between 1 and 65535, got: %s", c.Port)
+  }
+  switch c.TreeOrder {
+  case treeOrderDirsFirst, treeOrderFilesFirst, treeOrderAlpha:
+  default:
+    return fmt.Errorf("treeOrder must be one of %s, %s, %s, got: %s", treeOrderDirsFirst, treeOrderFilesFirst, treeOrderAlpha, c.TreeOrder)
+  }
+  switch c.OnFileError {
+  case onFileErrorSkip, onFileErrorFail:
+  default:
+    return fmt.Errorf("onFileError must be %s or %s, got: %s", onFileErrorSkip, onFileErrorFail, c.OnFileError)
+  }
+  for name := range c.Features {
+    if _, ok := defaultFeatures[name]; !ok {
+      return fmt.Errorf("unknown feature %q", name)
+    }
+  }
+  if c.MaxQueryLength < 1 || c.MaxQueryLength < c.MinQueryLength {
+    return fmt.Errorf("maxQueryLength must be at least 1 and minQueryLength, got: %d", c.MaxQueryLength)
+  }
+  if c.TagMinCount < 1 {
+    return fmt.Errorf("tagMinCount must be at least 1, got: %d", c.TagMinCount)
+  }
+  if err := validateMounts(c.Mounts); err != nil {
+    return err
+  }
+  if !isLanguage(c.Language) {
+    return fmt.Errorf("language must be one of %s, got: %s", strings.Join(languages(), ", "), c.Language)
+  }
+  return nil
+}
+
+// writeConfigFile saves c to path in the format its extension names. The
+// file is replaced atomically, so readers never see a partial write.
+func writeConfigFile(path string, c Config) error {
+  var data []byte
+  var err error
+  switch strings.ToLower(filepath.Ext(path)) {
+  case ".yaml", ".yml":
+    data, err = yaml.Marshal(c)
+  case ".toml":
+    var buf bytes.Buffer
+    err = toml.NewEncoder(&buf).Encode(c)
+    data = buf.Bytes()
+  default:
+    data, err = json.MarshalIndent(c, "", "  ")
+    data = append(data, '\n')
+  }
+  if err != nil {
+    return err
+  }
+  return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data by writing a temp file next to it
+// and renaming it into place.
+func writeFileAtomic(path string, data []byte) error {
+  tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  if _, err := tmp.Write(data); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp.Name(), path)
+}
+
+// Feature flags gate experimental features. A feature missing from the
+// features map has its default from defaultFeatures.
+const (
+  featureFuzzySearch = "fuzzy_search"
+  featureSSEProgress = "sse_progress"
+)
+
+var defaultFeatures = map[string]bool{
+  featureFuzzySearch: false,
+  featureSSEProgress: true,
+}
+
+// isFeatureEnabled reports whether the named feature is on in the config
+// in effect.
+func isFeatureEnabled(name string) bool {
+  if enabled, ok := currentConfig().Features[name]; ok {
+    return enabled
+  }
+  return defaultFeatures[name]
+}
+
+// applyEnvOverrides lets WIKA_* environment variables take precedence over
+// values read from config.json.
+func applyEnvOverrides(c *Config) {
+  if v, ok := os.LookupEnv("WIKA_PORT"); ok {
+    c.Port = v
+  }
+  if v, ok := os.LookupEnv("WIKA_DIRECTORY"); ok {
+    c.Directory = v
+  }
+  if v, ok := os.LookupEnv("WIKA_IPRANGES"); ok {
+    c.IPRanges = nil
+    for _, cidr := range splitList(v) {
+      c.IPRanges = append(c.IPRanges, IPRange{CIDR: cidr})
+    }
+  }
+}
+
+func splitList(s string) []string {
+  var items []string
+  for _, item := range strings.Split(s, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      items = append(items, item)
+    }
+  }
+  return items
+}
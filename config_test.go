@@ -0,0 +1,322 @@
+package main
+
+import (
+  "encoding/json"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+// writeConfig saves text as a config file called name in a temp directory
+// and returns its path.
+func writeConfig(t *testing.T, name, text string) string {
+  t.Helper()
+  path := filepath.Join(t.TempDir(), name)
+  if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+    t.Fatal(err)
+  }
+  return path
+}
+
+func TestEnvOverridesFileValues(t *testing.T) {
+  path := writeConfig(t, "config.json", `{"port": "8080", "directory": "/srv/wiki", "ipRanges": ["10.0.0.0/8"]}`)
+  t.Setenv("WIKA_PORT", "9090")
+  t.Setenv("WIKA_DIRECTORY", "/srv/other")
+  t.Setenv("WIKA_IPRANGES", "127.0.0.0/8, 192.168.0.0/16,")
+
+  cfg, err := loadConfig(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  applyEnvOverrides(&cfg)
+  if cfg.Port != "9090" {
+    t.Errorf("Port = %q, want 9090", cfg.Port)
+  }
+  if cfg.Directory != "/srv/other" {
+    t.Errorf("Directory = %q, want /srv/other", cfg.Directory)
+  }
+  want := []IPRange{{CIDR: "127.0.0.0/8"}, {CIDR: "192.168.0.0/16"}}
+  if !reflect.DeepEqual(cfg.IPRanges, want) {
+    t.Errorf("IPRanges = %v, want %v", cfg.IPRanges, want)
+  }
+}
+
+func TestEnvOverridesUnsetKeepFileValues(t *testing.T) {
+  path := writeConfig(t, "config.json", `{"port": "8080", "directory": "/srv/wiki"}`)
+  for _, name := range []string{"WIKA_PORT", "WIKA_DIRECTORY", "WIKA_IPRANGES"} {
+    // Setenv restores the variable after the test.
+    t.Setenv(name, "")
+    os.Unsetenv(name)
+  }
+
+  cfg, err := loadConfig(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  applyEnvOverrides(&cfg)
+  if cfg.Port != "8080" || cfg.Directory != "/srv/wiki" {
+    t.Errorf("got port %q and directory %q, want the file's 8080 and /srv/wiki", cfg.Port, cfg.Directory)
+  }
+}
+
+func TestConfigJSONRoundTrip(t *testing.T) {
+  want := defaultConfig()
+  want.IPRanges = []IPRange{{CIDR: "10.0.0.0/8"}, {CIDR: "192.168.1.0/24", Name: "office"}}
+  want.Directory = "/srv/wiki"
+  want.Templates = map[string]string{"compact": "compact.html"}
+
+  data, err := json.Marshal(want)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !strings.Contains(string(data), `"ipRanges":["10.0.0.0/8",{"cidr":"192.168.1.0/24","name":"office"}]`) {
+    t.Errorf("ipRanges encoded as %s", data)
+  }
+  got, err := loadConfig(writeConfig(t, "config.json", string(data)))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("decoded %+v, want %+v", got, want)
+  }
+}
+
+func TestValidateConfigPort(t *testing.T) {
+  for _, port := range []string{"", "abc", "80a", "-1", "0", "65536", "70000"} {
+    cfg := defaultConfig()
+    cfg.Port = port
+    if err := validateConfig(cfg); err == nil {
+      t.Errorf("port %q passed validation", port)
+    }
+  }
+  for _, port := range []string{"1", "8080", "65535"} {
+    cfg := defaultConfig()
+    cfg.Port = port
+    if err := validateConfig(cfg); err != nil {
+      t.Errorf("port %q: %v", port, err)
+    }
+  }
+  cfg := defaultConfig()
+  cfg.Port = "0"
+  cfg.AllowEphemeralPort = true
+  if err := validateConfig(cfg); err != nil {
+    t.Errorf("port 0 with allowEphemeralPort: %v", err)
+  }
+}
+
+func TestConfigIPRangesKey(t *testing.T) {
+  // Older files spell the key as the field name.
+  for _, key := range []string{"ipRanges", "IPRanges"} {
+    cfg, err := loadConfig(writeConfig(t, "config.json", `{"`+key+`": ["127.0.0.1/32"]}`))
+    if err != nil {
+      t.Fatalf("%s: %v", key, err)
+    }
+    if cfg.IPRanges == nil {
+      t.Fatalf("%s: IPRanges is nil", key)
+    }
+    if want := []IPRange{{CIDR: "127.0.0.1/32"}}; !reflect.DeepEqual(cfg.IPRanges, want) {
+      t.Errorf("%s: IPRanges = %v, want %v", key, cfg.IPRanges, want)
+    }
+  }
+}
+
+func TestIPRangeUnmarshal(t *testing.T) {
+  tests := []struct {
+    name string
+    file string
+    text string
+  }{
+    {"json", "config.json", `{"ipRanges": ["10.0.0.0/8", {"cidr": "192.168.1.0/24", "name": "office"}]}`},
+    {"yaml", "config.yaml", "ipRanges:\n  - 10.0.0.0/8\n  - cidr: 192.168.1.0/24\n    name: office\n"},
+    {"toml", "config.toml", `ipRanges = ["10.0.0.0/8", {cidr = "192.168.1.0/24", name = "office"}]`},
+  }
+  want := []IPRange{{CIDR: "10.0.0.0/8"}, {CIDR: "192.168.1.0/24", Name: "office"}}
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      cfg, err := loadConfig(writeConfig(t, tt.file, tt.text))
+      if err != nil {
+        t.Fatal(err)
+      }
+      if !reflect.DeepEqual(cfg.IPRanges, want) {
+        t.Errorf("IPRanges = %+v, want %+v", cfg.IPRanges, want)
+      }
+      if cfg.IPRanges[0].Label() != "10.0.0.0/8" || cfg.IPRanges[1].Label() != "office" {
+        t.Errorf("labels = %q, %q", cfg.IPRanges[0].Label(), cfg.IPRanges[1].Label())
+      }
+    })
+  }
+}
+
+func TestIPRangeUnmarshalRejectsUnknownFields(t *testing.T) {
+  for file, text := range map[string]string{
+    "config.json": `{"ipRanges": [{"cidr": "10.0.0.0/8", "label": "lan"}]}`,
+    "config.yaml": "ipRanges:\n  - cidr: 10.0.0.0/8\n    label: lan\n",
+    "config.toml": `ipRanges = [{cidr = "10.0.0.0/8", label = "lan"}]`,
+  } {
+    if _, err := loadConfig(writeConfig(t, file, text)); err == nil {
+      t.Errorf("%s: unknown IP range field accepted", file)
+    }
+  }
+}
+
+func TestLoadConfigRejectsUnknownAndDuplicateKeys(t *testing.T) {
+  tests := []struct {
+    file string
+    text string
+    want string
+  }{
+    {"config.json", `{"prot": "8080"}`, `unknown field "prot"`},
+    {"config.json", `{"basicAuth": {"user": "admin"}}`, `unknown field "user"`},
+    {"config.json", `{"port": "8080", "Port": "9090"}`, `duplicate config key "Port"`},
+    {"config.yaml", "prot: 8080\n", "field prot not found"},
+    {"config.yaml", "port: 8080\nport: 9090\n", "already defined"},
+    {"config.toml", `prot = "8080"`, `unknown key "prot"`},
+    {"config.toml", "port = \"8080\"\nport = \"9090\"\n", "already been defined"},
+  }
+  for _, tt := range tests {
+    _, err := loadConfig(writeConfig(t, tt.file, tt.text))
+    if err == nil || !strings.Contains(err.Error(), tt.want) {
+      t.Errorf("%s %q: error = %v, want one mentioning %s", tt.file, tt.text, err, tt.want)
+    }
+  }
+}
+
+func TestLoadConfigFromPath(t *testing.T) {
+  dir := t.TempDir()
+  def := filepath.Join(dir, "config.json")
+  if err := os.WriteFile(def, []byte(`{"port": "1111"}`), 0644); err != nil {
+    t.Fatal(err)
+  }
+  other := writeConfig(t, "site.json", `{"port": "2222", "siteTitle": "Other"}`)
+
+  cfg, err := loadConfig(other)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if cfg.Port != "2222" || cfg.SiteTitle != "Other" {
+    t.Errorf("loaded port %q, title %q from %s", cfg.Port, cfg.SiteTitle, other)
+  }
+  if cfg, _ := loadConfig(def); cfg.Port != "1111" || cfg.SiteTitle != "" {
+    t.Errorf("loaded port %q, title %q from %s", cfg.Port, cfg.SiteTitle, def)
+  }
+
+  // A missing file keeps the defaults rather than failing.
+  cfg, err = loadConfig(filepath.Join(dir, "missing.json"))
+  if err != nil || !reflect.DeepEqual(cfg, defaultConfig()) {
+    t.Errorf("missing file: %+v, %v", cfg, err)
+  }
+}
+
+// The same settings in each config format.
+const (
+  fullConfigJSON = `{
+  "port": "9000",
+  "ipRanges": [{"cidr": "10.0.0.0/8", "name": "office"}, "127.0.0.0/8"],
+  "directory": "/srv/wiki",
+  "templates": {"compact": "compact.html"},
+  "maxFileSize": 1048576,
+  "basicAuth": {"username": "admin", "hashedPassword": "abc123"},
+  "looseMatch": true,
+  "features": {"fuzzy_search": true},
+  "corsOrigins": ["https://example.com"],
+  "mounts": [{"prefix": "/archive/", "directory": "/srv/archive", "ipRanges": ["10.1.0.0/16"]}],
+  "snippetEllipsis": "..."
+}`
+  fullConfigYAML = `port: "9000"
+ipRanges:
+  - cidr: 10.0.0.0/8
+    name: office
+  - 127.0.0.0/8
+directory: /srv/wiki
+templates:
+  compact: compact.html
+maxFileSize: 1048576
+basicAuth:
+  username: admin
+  hashedPassword: abc123
+looseMatch: true
+features:
+  fuzzy_search: true
+corsOrigins: [https://example.com]
+mounts:
+  - prefix: /archive/
+    directory: /srv/archive
+    ipRanges: [10.1.0.0/16]
+snippetEllipsis: "..."
+`
+  fullConfigTOML = `port = "9000"
+ipRanges = [{cidr = "10.0.0.0/8", name = "office"}, "127.0.0.0/8"]
+directory = "/srv/wiki"
+maxFileSize = 1048576
+looseMatch = true
+corsOrigins = ["https://example.com"]
+snippetEllipsis = "..."
+
+[templates]
+compact = "compact.html"
+
+[basicAuth]
+username = "admin"
+hashedPassword = "abc123"
+
+[features]
+fuzzy_search = true
+
+[[mounts]]
+prefix = "/archive/"
+directory = "/srv/archive"
+ipRanges = ["10.1.0.0/16"]
+`
+)
+
+func TestYAMLConfigMatchesJSON(t *testing.T) {
+  fromJSON, err := loadConfig(writeConfig(t, "config.json", fullConfigJSON))
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, name := range []string{"config.yaml", "config.yml"} {
+    fromYAML, err := loadConfig(writeConfig(t, name, fullConfigYAML))
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !reflect.DeepEqual(fromYAML, fromJSON) {
+      t.Errorf("%s:\n%+v\nJSON:\n%+v", name, fromYAML, fromJSON)
+    }
+  }
+}
+
+func TestExampleConfigsAgree(t *testing.T) {
+  load := func(example, name string) Config {
+    data, err := os.ReadFile(example)
+    if err != nil {
+      t.Fatal(err)
+    }
+    cfg, err := loadConfig(writeConfig(t, name, string(data)))
+    if err != nil {
+      t.Fatalf("%s: %v", example, err)
+    }
+    return cfg
+  }
+  want := load("config.json.example", "config.json")
+  for example, name := range map[string]string{"config.yaml.example": "config.yaml", "config.toml.example": "config.toml"} {
+    if got := load(example, name); !reflect.DeepEqual(got, want) {
+      t.Errorf("%s:\n%+v\nconfig.json.example:\n%+v", example, got, want)
+    }
+  }
+}
+
+func TestTOMLConfigMatchesJSON(t *testing.T) {
+  fromJSON, err := loadConfig(writeConfig(t, "config.json", fullConfigJSON))
+  if err != nil {
+    t.Fatal(err)
+  }
+  fromTOML, err := loadConfig(writeConfig(t, "config.toml", fullConfigTOML))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !reflect.DeepEqual(fromTOML, fromJSON) {
+    t.Errorf("TOML:\n%+v\nJSON:\n%+v", fromTOML, fromJSON)
+  }
+}
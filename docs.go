@@ -0,0 +1,35 @@
+package main
+
+import (
+  "fmt"
+  "io/fs"
+  "os"
+  "github.com/Albatrosicks/temp-wika/assets"
+)
+
+// docsFS is the filesystem documents are searched, indexed and served
+// from: dir on disk, or the sample pages built into the binary when dir is
+// empty, as it is unless a directory is configured. All paths in it are
+// slash-separated and relative to its root.
+func docsFS(dir string) fs.FS {
+  if dir == "" {
+    return sampleDocs
+  }
+  return os.DirFS(dir)
+}
+
+var sampleDocs = func() fs.FS {
+  sub, err := fs.Sub(assets.Sample, "sample")
+  if err != nil {
+    panic(err)
+  }
+  return sub
+}()
+
+// describeDocs names where documents come from, for the startup log.
+func describeDocs(dir string) string {
+  if dir == "" {
+    return "the built-in sample pages"
+  }
+  return fmt.Sprintf("directory %s", dir)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+  "io/fs"
+  "net/http"
+  "strings"
+  "testing"
+)
+
+func TestSampleDocsWithoutDirectory(t *testing.T) {
+  useConfig(t, func(c *Config) { c.Directory = "" })
+  if docsFS("") != sampleDocs {
+    t.Fatal("docsFS(\"\") is not the built-in sample")
+  }
+  files, err := fs.Glob(sampleDocs, "it/*.html")
+  if err != nil || len(files) != 2 {
+    t.Fatalf("sample it/ pages = %v, %v", files, err)
+  }
+
+  idx := useIndex(t)
+  if doc, ok := idx.Docs["it/vpn.html"]; !ok || doc.Title != "Подключение к VPN" {
+    t.Errorf("indexed sample vpn page = %+v", doc)
+  }
+  found := false
+  for _, result := range searchAPI(t, "/api/search?q=VPN").Results {
+    found = found || result.Path == "it/vpn.html"
+  }
+  if !found {
+    t.Error("search for VPN in the sample did not find it/vpn.html")
+  }
+
+  base := startServer(t)
+  status, body := fetch(t, base+"/static/it/vpn.html")
+  if status != http.StatusOK || !strings.Contains(body, "<title>Подключение к VPN</title>") {
+    t.Errorf("/static/it/vpn.html: status %d, body %q", status, body)
+  }
+  if status, _ := fetch(t, base+"/static/missing.html"); status != http.StatusNotFound {
+    t.Errorf("/static/missing.html: status %d, want 404", status)
+  }
+}
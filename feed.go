@@ -0,0 +1,78 @@
+package main
+
+import (
+  "encoding/xml"
+  "fmt"
+  "net/http"
+  "sort"
+  "time"
+)
+
+type atomLink struct {
+  Href string `xml:"href,attr"`
+  Rel string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+  Title string `xml:"title"`
+  ID string `xml:"id"`
+  Link atomLink `xml:"link"`
+  Updated string `xml:"updated"`
+  Summary string `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+  XMLName xml.Name `xml:"feed"`
+  Xmlns string `xml:"xmlns,attr"`
+  Title string `xml:"title"`
+  ID string `xml:"id"`
+  Link atomLink `xml:"link"`
+  Updated string `xml:"updated"`
+  Author string `xml:"author>name"`
+  Entries []atomEntry `xml:"entry"`
+}
+
+// writeAtomFeed writes results as an Atom feed, newest first, so a saved
+// search can be polled by a feed reader.
+func writeAtomFeed(w http.ResponseWriter, r *http.Request, query string, results []SearchResult) {
+  sort.Slice(results, func(i, j int) bool { return results[i].Modified.After(results[j].Modified) })
+  lang := requestLanguage(r)
+  self := absoluteURL(r, r.URL.EscapedPath()) + "?" + r.URL.RawQuery
+  updated := newestMtime(results)
+  if updated.IsZero() {
+    updated = currentIndex().Built
+  }
+  feed := atomFeed{
+    Xmlns: "http://www.w3.org/2005/Atom",
+    Title: siteTitle(lang) + ": " + query,
+    ID: self,
+    Link: atomLink{Href: self, Rel: "self"},
+    Updated: updated.UTC().Format(time.RFC3339),
+    Author: siteTitle(lang),
+  }
+  docs := currentIndex().Docs
+  for _, result := range results {
+    link := absoluteURL(r, result.URL)
+    entry := atomEntry{
+      Title: result.Path,
+      ID: link,
+      Link: atomLink{Href: link},
+      Updated: result.Modified.UTC().Format(time.RFC3339),
+    }
+    if result.Title != "" {
+      entry.Title = result.Title
+    }
+    if doc, ok := docs[result.Path]; ok {
+      entry.Summary = doc.Snippet
+    }
+    feed.Entries = append(feed.Entries, entry)
+  }
+
+  w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+  w.Write([]byte(xml.Header))
+  enc := xml.NewEncoder(w)
+  enc.Indent("", "  ")
+  if err := enc.Encode(feed); err != nil {
+    fmt.Println("Error generating feed: ", err)
+  }
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+  "encoding/xml"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestAtomFeed(t *testing.T) {
+  dir := writeDocs(t, map[string]string{
+    "old.html": "<title>Old printer</title><p>printer setup</p>",
+    "new.html": "<title>New printer</title><p>printer drivers</p>",
+  })
+  older := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+  newer := older.Add(48 * time.Hour)
+  for name, mtime := range map[string]time.Time{"old.html": older, "new.html": newer} {
+    if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+      t.Fatal(err)
+    }
+  }
+  useConfig(t, func(c *Config) { c.Directory = dir })
+  useIndex(t)
+
+  w := get(handleSearch, "/?q=printer&format=atom")
+  if w.Code != 200 {
+    t.Fatalf("status = %d, want 200", w.Code)
+  }
+  if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+    t.Errorf("Content-Type = %q", ct)
+  }
+  if !strings.HasPrefix(w.Body.String(), xml.Header) {
+    t.Error("feed has no XML declaration")
+  }
+  var feed struct {
+    XMLName xml.Name
+    Title string `xml:"title"`
+    ID string `xml:"id"`
+    Updated string `xml:"updated"`
+    Link atomLink `xml:"link"`
+    Author string `xml:"author>name"`
+    Entries []struct {
+      Title string `xml:"title"`
+      ID string `xml:"id"`
+      Link atomLink `xml:"link"`
+      Updated string `xml:"updated"`
+      Summary string `xml:"summary"`
+    } `xml:"entry"`
+  }
+  if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+    t.Fatal(err)
+  }
+  if feed.XMLName.Space != "http://www.w3.org/2005/Atom" || feed.XMLName.Local != "feed" {
+    t.Errorf("root element = %+v, want Atom feed", feed.XMLName)
+  }
+  if !strings.HasSuffix(feed.Title, ": printer") || feed.Author == "" {
+    t.Errorf("title = %q, author = %q", feed.Title, feed.Author)
+  }
+  if feed.Link.Rel != "self" || feed.Link.Href != feed.ID || !strings.Contains(feed.ID, "format=atom") {
+    t.Errorf("self link = %+v, id = %q", feed.Link, feed.ID)
+  }
+  if want := newer.Format(time.RFC3339); feed.Updated != want {
+    t.Errorf("feed updated = %q, want newest entry %q", feed.Updated, want)
+  }
+  if len(feed.Entries) != 2 {
+    t.Fatalf("got %d entries, want 2", len(feed.Entries))
+  }
+  for i, want := range []struct {
+    title string
+    mtime time.Time
+  }{{"New printer", newer}, {"Old printer", older}} {
+    entry := feed.Entries[i]
+    if entry.Title != want.title || entry.Updated != want.mtime.Format(time.RFC3339) {
+      t.Errorf("entry %d = %q updated %q, want %q updated %s", i, entry.Title, entry.Updated, want.title, want.mtime.Format(time.RFC3339))
+    }
+    if !strings.HasPrefix(entry.ID, "http://") || entry.Link.Href != entry.ID {
+      t.Errorf("entry %d id = %q, link = %q", i, entry.ID, entry.Link.Href)
+    }
+    if !strings.Contains(entry.Summary, "printer") {
+      t.Errorf("entry %d summary = %q", i, entry.Summary)
+    }
+  }
+
+  w = get(handleSearch, "/?q=nothingmatches&format=atom")
+  if w.Code != 200 || !strings.Contains(w.Body.String(), "<feed") || strings.Contains(w.Body.String(), "<entry>") {
+    t.Errorf("empty feed: status %d, body %s", w.Code, w.Body)
+  }
+}
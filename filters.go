@@ -0,0 +1,111 @@
+package main
+
+import (
+  "net/http"
+  "path"
+  "sort"
+  "strings"
+  "sync"
+)
+
+// topDirsCache holds the first-level directories of the index they were
+// listed from, so the search form's directory list is only rebuilt after a
+// reindex.
+var topDirsCache struct {
+  sync.Mutex
+  index *Index
+  dirs []string
+}
+
+// topDirs lists the directories directly under the wiki root that contain
+// indexed documents, for the ?path= filter. Dot directories are left out,
+// as their documents are not listed anywhere.
+func topDirs() []string {
+  idx := currentIndex()
+  topDirsCache.Lock()
+  defer topDirsCache.Unlock()
+  if topDirsCache.index == idx {
+    return topDirsCache.dirs
+  }
+  seen := map[string]bool{}
+  dirs := []string{}
+  for _, p := range idx.Paths {
+    if i := strings.Index(p, "/"); i > 0 && !seen[p[:i]] {
+      seen[p[:i]] = true
+      dirs = append(dirs, p[:i])
+    }
+  }
+  sort.Strings(dirs)
+  topDirsCache.index = idx
+  topDirsCache.dirs = dirs
+  return dirs
+}
+
+// searchExtensions are the file extensions ?ext= accepts, one per search
+// pattern.
+func searchExtensions() []string {
+  var exts []string
+  for _, pattern := range searchPatterns {
+    exts = append(exts, strings.TrimPrefix(pattern, "*"))
+  }
+  return exts
+}
+
+// fileExt is the extension of file among searchExtensions, so that
+// "a.html.gz" is ".html.gz" rather than ".gz".
+func fileExt(file string) string {
+  name := path.Base(file)
+  ext := ""
+  for _, e := range searchExtensions() {
+    if strings.HasSuffix(name, e) && len(e) > len(ext) {
+      ext = e
+    }
+  }
+  return ext
+}
+
+func validDir(dir string) bool {
+  return dir == "" || (!strings.ContainsAny(dir, `/\`) && dir != "." && dir != "..")
+}
+
+func validExt(ext string) bool {
+  if ext == "" {
+    return true
+  }
+  for _, e := range searchExtensions() {
+    if ext == e {
+      return true
+    }
+  }
+  return false
+}
+
+// filterFiles keeps the files that are in the top-level directory opts.Dir,
+// have the extension opts.Ext and carry the tag opts.Tag, when those are
+// set, so filtered-out files are never read.
+func filterFiles(files []string, opts searchOptions) []string {
+  if opts.Dir == "" && opts.Ext == "" && opts.Tag == "" {
+    return files
+  }
+  idx := currentIndex()
+  var kept []string
+  for _, file := range files {
+    if opts.Dir != "" && !strings.HasPrefix(file, opts.Dir+"/") {
+      continue
+    }
+    if opts.Ext != "" && fileExt(file) != opts.Ext {
+      continue
+    }
+    if opts.Tag != "" && !taggedWith(idx, opts.Tag, logicalPath(file)) {
+      continue
+    }
+    kept = append(kept, file)
+  }
+  return kept
+}
+
+// searchFilters returns the ?path= and ?ext= filters of r, for the search
+// form to show them selected.
+func searchFilters(r *http.Request) (dir, ext string) {
+  return r.URL.Query().Get("path"), r.URL.Query().Get("ext")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+  "context"
+  "html/template"
+  "net/http"
+  "net/url"
+  "strconv"
+  "sort"
+  "strings"
+)
+
+const maxSuggestions = 10
+
+var fragmentResultsTemplate = template.Must(template.New("fragment").Parse(
+`<ul class="wika-results">{{range .}}<li><a href="{{.URL}}" title="{{.Path}}">{{if .Title}}{{.Title}}{{else}}{{.Path}}{{end}}</a></li>{{end}}</ul>`))
+
+var fragmentMessageTemplate = template.Must(template.New("message").Parse(
+`<p class="wika-message">{{.}}</p>`))
+
+// allowCORS sets CORS headers when the request comes from one of the
+// configured origins and reports whether the request was a preflight that
+// has been answered.
+func allowCORS(w http.ResponseWriter, r *http.Request) bool {
+  origin := r.Header.Get("Origin")
+  if origin == "" {
+    return false
+  }
+  w.Header().Add("Vary", "Origin")
+  allowed := false
+  for _, o := range currentConfig().CORSOrigins {
+    if o == "*" || strings.EqualFold(o, origin) {
+      allowed = true
+      break
+    }
+  }
+  if !allowed {
+    return false
+  }
+  w.Header().Set("Access-Control-Allow-Origin", origin)
+  if r.Method == http.MethodOptions {
+    w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+    w.Header().Set("Access-Control-Allow-Headers", "HX-Request, HX-Current-URL, HX-Target, HX-Trigger, HX-Trigger-Name")
+    w.Header().Set("Access-Control-Max-Age", "600")
+    w.WriteHeader(http.StatusNoContent)
+    return true
+  }
+  return false
+}
+
+// absoluteURL makes links usable from pages served on other hosts. p must
+// already be escaped.
+func absoluteURL(r *http.Request, p string) string {
+  scheme := "http"
+  if r.TLS != nil {
+    scheme = "https"
+  }
+  u := url.URL{Scheme: scheme, Host: r.Host}
+  return u.String() + p
+}
+
+func handleFragmentSearch(w http.ResponseWriter, r *http.Request) {
+  if allowCORS(w, r) || !checkAccess(w, r) {
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if building, percent := indexBuilding(); building {
+    w.Header().Set("Retry-After", strconv.Itoa(indexingRetryAfter))
+    w.WriteHeader(http.StatusServiceUnavailable)
+    fragmentMessageTemplate.Execute(w, translate(requestLanguage(r), "error.indexing", percent))
+    return
+  }
+
+  query, err, _ := validateSearchParams(r)
+  if err == errEmptyQuery {
+    fragmentResultsTemplate.Execute(w, []SearchResult{})
+    return
+  }
+  if err != nil {
+    fragmentMessageTemplate.Execute(w, queryErrorMessage(requestLanguage(r), err))
+    return
+  }
+
+  ctx, cancel := searchContext(r)
+  defer cancel()
+  results := []SearchResult{}
+  cfg := currentConfig()
+  err = searchDocuments(ctx, docsFS(cfg.Directory), query, searchOptionsFor(r), func(result SearchResult) error {
+    result.URL = absoluteURL(r, result.URL)
+    results = append(results, result)
+    if cfg.MaxResults > 0 && len(results) >= cfg.MaxResults {
+      return errStopSearch
+    }
+    return nil
+  })
+  if err != nil {
+    if r.Context().Err() != nil {
+      return
+    }
+    if ctx.Err() != context.DeadlineExceeded {
+      id := logSearchError(w, err)
+      http.Error(w, translate(requestLanguage(r), "error.searching", id), http.StatusInternalServerError)
+      return
+    }
+    // Whatever was found before the timeout is shown under a notice.
+    fragmentMessageTemplate.Execute(w, translate(requestLanguage(r), "error.search_timeout"))
+    if len(results) > 0 {
+      fragmentResultsTemplate.Execute(w, results)
+    }
+    return
+  }
+  if len(results) == 0 {
+    fragmentMessageTemplate.Execute(w, translate(requestLanguage(r), "error.no_results"))
+    return
+  }
+  fragmentResultsTemplate.Execute(w, results)
+}
+
+// handleFragmentSuggest lists indexed pages whose title contains q.
+func handleFragmentSuggest(w http.ResponseWriter, r *http.Request) {
+  if allowCORS(w, r) || !checkAccess(w, r) {
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+  query, err, _ := validateSearchParams(r)
+  query = strings.ToLower(query)
+  suggestions := []SearchResult{}
+  if err == nil {
+    for _, doc := range currentIndex().Docs {
+      if doc.Title != "" && strings.Contains(strings.ToLower(doc.Title), query) {
+        suggestions = append(suggestions, SearchResult{Path: doc.Path, URL: absoluteURL(r, staticLinkEscaped(doc.Path)), Title: doc.Title})
+      }
+    }
+  }
+  sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].Title < suggestions[j].Title })
+  if len(suggestions) > maxSuggestions {
+    suggestions = suggestions[:maxSuggestions]
+  }
+  fragmentResultsTemplate.Execute(w, suggestions)
+}
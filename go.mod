@@ -2,4 +2,10 @@ module github.com/Albatrosicks/temp-wika
 
 go 1.21.3
 
-require golang.org/x/net v0.17.0 // indirect
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/hashicorp/golang-lru/v2 v2.0.7
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,92 @@
+package main
+
+import (
+  "bytes"
+  "compress/gzip"
+  "context"
+  "errors"
+  "io"
+  "io/fs"
+  "mime"
+  "net/http"
+  "path"
+  "strings"
+)
+
+var errBadGzip = errors.New("malformed gzip data")
+
+// errTooLarge is returned for a .gz file that decompresses to more than
+// maxFileSize.
+var errTooLarge = errors.New("decompressed document is too large")
+
+// logicalPath strips the .gz suffix so compressed pages are indexed and
+// linked under the name of the page they contain.
+func logicalPath(p string) string {
+  return strings.TrimSuffix(p, ".gz")
+}
+
+// readDocument reads a searchable file from fsys, decompressing .gz files.
+// Decompression stops past maxFileSize, so a small file can't expand into
+// more than a plain one may hold.
+func readDocument(ctx context.Context, fsys fs.FS, file string) ([]byte, error) {
+  content, err := readFileCtx(ctx, fsys, file)
+  if err != nil || !strings.HasSuffix(file, ".gz") {
+    return content, err
+  }
+  zr, err := gzip.NewReader(bytes.NewReader(content))
+  if err != nil {
+    return nil, errBadGzip
+  }
+  defer zr.Close()
+  var r io.Reader = zr
+  limit := currentConfig().MaxFileSize
+  if limit > 0 {
+    r = io.LimitReader(zr, limit+1)
+  }
+  content, err = io.ReadAll(ctxReader{ctx, r})
+  if err != nil {
+    if ctx.Err() != nil {
+      return nil, ctx.Err()
+    }
+    return nil, errBadGzip
+  }
+  if limit > 0 && int64(len(content)) > limit {
+    return nil, errTooLarge
+  }
+  return content, nil
+}
+
+// staticHandler serves files from docs like http.FileServer, and when a
+// requested file only exists as a .gz it serves the decompressed content.
+func staticHandler(docs fs.FS) http.Handler {
+  files := http.FileServer(http.FS(docs))
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+    if name == "" || strings.HasSuffix(name, ".gz") {
+      files.ServeHTTP(w, r)
+      return
+    }
+    if _, err := fs.Stat(docs, name); err == nil {
+      files.ServeHTTP(w, r)
+      return
+    }
+    info, err := fs.Stat(docs, name+".gz")
+    if err != nil || info.IsDir() {
+      files.ServeHTTP(w, r)
+      return
+    }
+    content, err := readDocument(r.Context(), docs, name+".gz")
+    if err == errTooLarge {
+      http.Error(w, translate(requestLanguage(r), "error.too_large"), http.StatusRequestEntityTooLarge)
+      return
+    }
+    if err != nil {
+      http.Error(w, translate(requestLanguage(r), "error.reading_file"), http.StatusInternalServerError)
+      return
+    }
+    if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+      w.Header().Set("Content-Type", ctype)
+    }
+    http.ServeContent(w, r, path.Base(name), info.ModTime(), bytes.NewReader(content))
+  })
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+  "bytes"
+  "compress/gzip"
+  "context"
+  "net/http"
+  "strings"
+  "testing"
+)
+
+func gzipText(t testing.TB, text string) string {
+  t.Helper()
+  var buf bytes.Buffer
+  zw := gzip.NewWriter(&buf)
+  if _, err := zw.Write([]byte(text)); err != nil {
+    t.Fatal(err)
+  }
+  if err := zw.Close(); err != nil {
+    t.Fatal(err)
+  }
+  return buf.String()
+}
+
+func TestReadDocumentGzip(t *testing.T) {
+  page := "<title>Zipped</title><p>compressed page</p>"
+  // Compresses to far less than the 1000-byte limit.
+  bomb := "<p>" + strings.Repeat("a", 5000) + "</p>"
+  exact := strings.Repeat("b", 1000)
+  useConfig(t, func(c *Config) { c.MaxFileSize = 1000 })
+  docs := docsFS(writeDocs(t, map[string]string{
+    "page.html.gz": gzipText(t, page),
+    "bomb.html.gz": gzipText(t, bomb),
+    "exact.html.gz": gzipText(t, exact),
+    "broken.html.gz": "not gzip at all",
+  }))
+
+  content, err := readDocument(context.Background(), docs, "page.html.gz")
+  if err != nil || string(content) != page {
+    t.Errorf("page.html.gz = %q, %v", content, err)
+  }
+  if _, err := readDocument(context.Background(), docs, "bomb.html.gz"); err != errTooLarge {
+    t.Errorf("bomb.html.gz error = %v, want errTooLarge", err)
+  }
+  if content, err := readDocument(context.Background(), docs, "exact.html.gz"); err != nil || len(content) != 1000 {
+    t.Errorf("exact.html.gz = %d bytes, %v; want all 1000", len(content), err)
+  }
+  if _, err := readDocument(context.Background(), docs, "broken.html.gz"); err != errBadGzip {
+    t.Errorf("broken.html.gz error = %v, want errBadGzip", err)
+  }
+}
+
+func TestIndexGzip(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "page.html.gz": gzipText(t, "<title>Zipped</title><p>compressed page</p>"),
+    "bomb.html.gz": gzipText(t, "<p>"+strings.Repeat("a", 5000)+"</p>"),
+  }, func(c *Config) { c.MaxFileSize = 1000 })
+
+  idx := currentIndex()
+  if doc, ok := idx.Docs["page.html"]; !ok || doc.Title != "Zipped" {
+    t.Errorf("page.html.gz indexed as %+v", idx.Docs)
+  }
+  for _, entry := range idx.Files {
+    if entry.Path == "bomb.html" && (!entry.Skipped || entry.Reason != "too large") {
+      t.Errorf("bomb.html.gz entry = %+v, want skipped as too large", entry)
+    }
+  }
+  w := get(handleAPISearch, "/api/search?q=aaaa")
+  if !strings.Contains(w.Body.String(), `"total":0`) {
+    t.Errorf("search matched the oversized page: %s", w.Body)
+  }
+  if w := get(handleText, "/api/text?path=bomb.html"); w.Code != http.StatusRequestEntityTooLarge {
+    t.Errorf("/api/text status = %d, want 413", w.Code)
+  }
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "net/http"
+  "strings"
+  "sync"
+)
+
+// historyCookie holds the browser's own recent searches, newest first, as
+// base64 JSON followed by an HMAC of it. Nothing is kept on the server. It
+// is not HttpOnly, so scripts may read it.
+const historyCookie = "searches"
+
+// maxHistory is how many searches the cookie remembers; maxHistoryBytes
+// caps its value, dropping the oldest searches until it fits.
+const (
+  maxHistory = 10
+  maxHistoryBytes = 2048
+)
+
+var historyKeyOnce sync.Once
+var randomHistoryKey []byte
+
+// historyKey signs the history cookie: cookieSecret from the config, or a
+// key made at startup, in which case histories don't survive a restart.
+func historyKey() []byte {
+  if secret := currentConfig().CookieSecret; secret != "" {
+    return []byte(secret)
+  }
+  historyKeyOnce.Do(func() {
+    randomHistoryKey = make([]byte, 32)
+    rand.Read(randomHistoryKey)
+  })
+  return randomHistoryKey
+}
+
+func signHistory(payload string) string {
+  mac := hmac.New(sha256.New, historyKey())
+  mac.Write([]byte(payload))
+  return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encodeHistory is the cookie value for searches, or "" when even one
+// search doesn't fit in maxHistoryBytes.
+func encodeHistory(searches []string) string {
+  for n := len(searches); n > 0; n-- {
+    data, err := json.Marshal(searches[:n])
+    if err != nil {
+      return ""
+    }
+    payload := base64.RawURLEncoding.EncodeToString(data)
+    if value := payload + "." + signHistory(payload); len(value) <= maxHistoryBytes {
+      return value
+    }
+  }
+  return ""
+}
+
+// decodeHistory reads a cookie value written by encodeHistory. Values that
+// are malformed or not signed with the current key read as no history.
+func decodeHistory(value string) []string {
+  payload, sig, ok := strings.Cut(value, ".")
+  if !ok || !hmac.Equal([]byte(sig), []byte(signHistory(payload))) {
+    return nil
+  }
+  data, err := base64.RawURLEncoding.DecodeString(payload)
+  if err != nil {
+    return nil
+  }
+  var searches []string
+  if json.Unmarshal(data, &searches) != nil || len(searches) > maxHistory {
+    return nil
+  }
+  return searches
+}
+
+// recentSearches is the browser's search history, or nil when the feature
+// is disabled.
+func recentSearches(r *http.Request) []string {
+  if currentConfig().DisableSearchHistory {
+    return nil
+  }
+  c, err := r.Cookie(historyCookie)
+  if err != nil {
+    return nil
+  }
+  return decodeHistory(c.Value)
+}
+
+// withSearch is the history of r once query is added: moved to the front
+// if it was already there, and the oldest dropped past maxHistory. An
+// empty query, as in a search by tag alone, is not added.
+func withSearch(r *http.Request, query string) []string {
+  if currentConfig().DisableSearchHistory {
+    return nil
+  }
+  if query == "" {
+    return recentSearches(r)
+  }
+  searches := []string{query}
+  for _, s := range recentSearches(r) {
+    if s != query && len(searches) < maxHistory {
+      searches = append(searches, s)
+    }
+  }
+  return searches
+}
+
+// rememberSearch adds query to the history cookie. It must be called
+// before anything is written.
+func rememberSearch(w http.ResponseWriter, r *http.Request, query string) {
+  searches := withSearch(r, query)
+  if searches == nil {
+    return
+  }
+  if value := encodeHistory(searches); value != "" {
+    setHistoryCookie(w, value, 365*24*60*60)
+  }
+}
+
+func setHistoryCookie(w http.ResponseWriter, value string, maxAge int) {
+  http.SetCookie(w, &http.Cookie{
+    Name: historyCookie,
+    Value: value,
+    Path: "/",
+    MaxAge: maxAge,
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+// handleClearHistory forgets the browser's searches and goes back to the
+// search page.
+func handleClearHistory(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", "POST")
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  setHistoryCookie(w, "", -1)
+  http.Redirect(w, r, "/", http.StatusSeeOther)
+}
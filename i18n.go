@@ -0,0 +1,113 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "path"
+  "sort"
+  "strings"
+  "sync"
+  "golang.org/x/text/language"
+  "github.com/Albatrosicks/temp-wika/assets"
+)
+
+// defaultLanguage's catalog is the reference: every other catalog is checked
+// against its keys, and its strings stand in for missing translations.
+const defaultLanguage = "ru"
+
+var (
+  catalogsMu sync.RWMutex
+  catalogs = map[string]map[string]string{}
+)
+
+// languages lists the embedded catalogs, so adding i18n/<lang>.json is all
+// it takes to support a language.
+func languages() []string {
+  files, _ := fs.Glob(assets.FS, "i18n/*.json")
+  var langs []string
+  for _, file := range files {
+    langs = append(langs, strings.TrimSuffix(path.Base(file), ".json"))
+  }
+  sort.Strings(langs)
+  return langs
+}
+
+func isLanguage(lang string) bool {
+  for _, l := range languages() {
+    if l == lang {
+      return true
+    }
+  }
+  return false
+}
+
+// loadCatalogs reads every catalog, preferring a copy in assetsDir, and
+// logs keys that are missing compared to the default language.
+func loadCatalogs() {
+  loaded := map[string]map[string]string{}
+  for _, lang := range languages() {
+    text, err := readAsset("i18n/" + lang + ".json")
+    messages := map[string]string{}
+    if err == nil {
+      err = json.Unmarshal(text, &messages)
+    }
+    if err != nil {
+      fmt.Println("Error loading messages for", lang, ":", err)
+      continue
+    }
+    loaded[lang] = messages
+  }
+  for lang, messages := range loaded {
+    for key := range loaded[defaultLanguage] {
+      if _, ok := messages[key]; !ok {
+        fmt.Println("Missing", lang, "translation for", key)
+      }
+    }
+  }
+  catalogsMu.Lock()
+  catalogs = loaded
+  catalogsMu.Unlock()
+}
+
+// translate looks key up in lang's catalog, falling back to the default
+// language and then to the key itself, and formats args into the result.
+func translate(lang, key string, args ...interface{}) string {
+  catalogsMu.RLock()
+  message, ok := catalogs[lang][key]
+  if !ok {
+    message, ok = catalogs[defaultLanguage][key]
+  }
+  catalogsMu.RUnlock()
+  if !ok {
+    message = key
+  }
+  if len(args) > 0 {
+    return fmt.Sprintf(message, args...)
+  }
+  return message
+}
+
+// requestLanguage is the configured language, or with autoDetectLanguage
+// the best match for the request's Accept-Language header.
+func requestLanguage(r *http.Request) string {
+  cfg := currentConfig()
+  if !cfg.AutoDetectLanguage {
+    return cfg.Language
+  }
+  accept, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+  if err != nil || len(accept) == 0 {
+    return cfg.Language
+  }
+  langs := languages()
+  var tags []language.Tag
+  for _, lang := range langs {
+    tags = append(tags, language.Make(lang))
+  }
+  _, i, confidence := language.NewMatcher(tags).Match(accept...)
+  if confidence == language.No {
+    return cfg.Language
+  }
+  return langs[i]
+}
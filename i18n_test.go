@@ -0,0 +1,86 @@
+package main
+
+import (
+  "encoding/json"
+  "io/fs"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "testing"
+  "github.com/Albatrosicks/temp-wika/assets"
+)
+
+func readCatalog(t *testing.T, lang string) map[string]string {
+  t.Helper()
+  data, err := assets.FS.ReadFile("i18n/" + lang + ".json")
+  if err != nil {
+    t.Fatal(err)
+  }
+  messages := map[string]string{}
+  if err := json.Unmarshal(data, &messages); err != nil {
+    t.Fatalf("%s.json: %v", lang, err)
+  }
+  return messages
+}
+
+func TestCatalogsHaveTheSameKeys(t *testing.T) {
+  langs := languages()
+  if len(langs) < 2 {
+    t.Fatalf("catalogs = %v, want en and ru at least", langs)
+  }
+  catalogs := map[string]map[string]string{}
+  for _, lang := range langs {
+    catalogs[lang] = readCatalog(t, lang)
+  }
+  for _, lang := range langs {
+    for _, other := range langs {
+      for key := range catalogs[lang] {
+        if _, ok := catalogs[other][key]; !ok {
+          t.Errorf("%s is in %s.json but not in %s.json", key, lang, other)
+        }
+      }
+    }
+  }
+}
+
+// messageKey finds keys passed to translate in Go code and to t in
+// templates.
+var messageKey = regexp.MustCompile(`(?:translate\([^,()]+(?:\([^)]*\))?, |\{\{-? *t \S+ )"([a-z0-9_]+\.[a-z0-9_.]+)"`)
+
+func TestUsedKeysAreInCatalogs(t *testing.T) {
+  used := map[string]bool{}
+  goFiles, _ := filepath.Glob("*.go")
+  for _, file := range goFiles {
+    data, err := os.ReadFile(file)
+    if err != nil {
+      t.Fatal(err)
+    }
+    for _, m := range messageKey.FindAllStringSubmatch(string(data), -1) {
+      used[m[1]] = true
+    }
+  }
+  templates, _ := fs.Glob(assets.FS, "*.html")
+  for _, file := range templates {
+    data, _ := assets.FS.ReadFile(file)
+    for _, m := range messageKey.FindAllStringSubmatch(string(data), -1) {
+      used[m[1]] = true
+    }
+  }
+  if len(used) < 20 {
+    t.Fatalf("found only %d message keys in use", len(used))
+  }
+  var keys []string
+  for key := range used {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  for _, lang := range languages() {
+    catalog := readCatalog(t, lang)
+    for _, key := range keys {
+      if _, ok := catalog[key]; !ok {
+        t.Errorf("%s is used but missing from %s.json", key, lang)
+      }
+    }
+  }
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "path"
+  "sort"
+  "strings"
+  "sync/atomic"
+  "time"
+  "golang.org/x/net/html"
+)
+
+type TOCEntry struct {
+  Level int `json:"level"`
+  Text string `json:"text"`
+  ID string `json:"id,omitempty"`
+}
+
+type Document struct {
+  Path string `json:"path"`
+  Title string `json:"title"`
+  Description string `json:"description"`
+  Author string `json:"author"`
+  Keywords []string `json:"keywords"`
+  WordCount int `json:"word_count"`
+  ReadingTimeMin int `json:"reading_time_min"`
+  Modified time.Time `json:"modified"`
+  Size int64 `json:"size"`
+  Backlinks []string `json:"backlinks"`
+  OutboundLinks []string `json:"outbound_links"`
+  TOC []TOCEntry `json:"toc"`
+  Snippet string `json:"snippet"`
+  // terms counts the words of the body until the index is built, which
+  // turns them into a vector for finding related pages.
+  terms map[string]int
+  vector map[string]float64
+}
+
+type Index struct {
+  Docs map[string]*Document
+  Files []FileEntry
+  Errors []string
+  Built time.Time
+  Duration time.Duration
+  Tokens int
+  Bytes int64
+  // Paths are the sorted paths of documents outside dot directories, for
+  // picking a random page.
+  Paths []string
+  // Terms counts the documents each body term occurs in, for suggesting
+  // a query when one finds nothing.
+  Terms map[string]int
+  // Tags maps each normalized meta keyword to the sorted paths of the
+  // documents carrying it, leaving out dot directories as Paths does.
+  Tags map[string][]string
+}
+
+// index holds the current snapshot. A snapshot is never modified once
+// published: rebuilds create a new one and swap the pointer, so readers
+// need no lock.
+var index atomic.Pointer[Index]
+
+var emptyIndex = &Index{Docs: map[string]*Document{}}
+
+func currentIndex() *Index {
+  if idx := index.Load(); idx != nil {
+    return idx
+  }
+  return emptyIndex
+}
+
+func setIndex(idx *Index) {
+  index.Store(idx)
+}
+
+const snippetLength = 300
+const wordsPerMinute = 200
+
+type FileEntry struct {
+  Path string `json:"path"`
+  Size int64 `json:"size"`
+  Modified time.Time `json:"modified"`
+  Title string `json:"title,omitempty"`
+  Skipped bool `json:"skipped"`
+  Reason string `json:"reason,omitempty"`
+  failed bool
+}
+
+// buildIndex indexes every searchable file in fsys, calling progress
+// after each file with the number done so far and the total.
+func buildIndex(fsys fs.FS, maxFileSize int64, progress func(indexed, total int)) (*Index, error) {
+  files, err := searchFiles(context.Background(), fsys, searchPatterns)
+  if err != nil {
+    return nil, err
+  }
+
+  idx := &Index{Docs: map[string]*Document{}, Built: time.Now()}
+  df := map[string]int{}
+  for i, file := range files {
+    doc, entry := indexFile(fsys, file, maxFileSize)
+    progress(i+1, len(files))
+    idx.Files = append(idx.Files, entry)
+    if doc == nil {
+      fmt.Println("Skipping", file, ":", entry.Reason)
+      if entry.failed {
+        idx.Errors = append(idx.Errors, entry.Path+": "+entry.Reason)
+      }
+      continue
+    }
+    idx.Docs[doc.Path] = doc
+    if !isHiddenPath(doc.Path) {
+      idx.Paths = append(idx.Paths, doc.Path)
+    }
+    idx.Bytes += doc.Size
+    for term := range doc.terms {
+      df[term]++
+    }
+  }
+  idx.Tokens = len(df)
+  idx.Terms = df
+  termVectors(idx, df)
+  sort.Slice(idx.Files, func(i, j int) bool {
+    return idx.Files[i].Path < idx.Files[j].Path
+  })
+  sort.Strings(idx.Paths)
+  idx.Tags = buildTags(idx.Docs, idx.Paths)
+
+  // A page linking to another several times is one backlink.
+  for _, doc := range idx.Docs {
+    seen := map[string]bool{}
+    for _, link := range doc.OutboundLinks {
+      if target, ok := idx.Docs[link]; ok && target != doc && !seen[link] {
+        seen[link] = true
+        target.Backlinks = append(target.Backlinks, doc.Path)
+      }
+    }
+  }
+  for _, doc := range idx.Docs {
+    sort.Strings(doc.Backlinks)
+  }
+  idx.Duration = time.Since(idx.Built)
+  return idx, nil
+}
+
+// indexFile always returns a FileEntry describing the file; the Document is
+// nil when the file was skipped, with the reason recorded in the entry.
+func indexFile(fsys fs.FS, file string, maxFileSize int64) (*Document, FileEntry) {
+  entry := FileEntry{Path: logicalPath(file)}
+  skip := func(reason string) (*Document, FileEntry) {
+    entry.Skipped = true
+    entry.Reason = reason
+    return nil, entry
+  }
+  fail := func(reason string) (*Document, FileEntry) {
+    entry.failed = true
+    return skip(reason)
+  }
+
+  info, err := fs.Stat(fsys, file)
+  if err != nil {
+    return fail("stat error: " + err.Error())
+  }
+  entry.Size = info.Size()
+  entry.Modified = info.ModTime()
+  if maxFileSize > 0 && info.Size() > maxFileSize {
+    return skip("too large")
+  }
+  content, err := readDocument(context.Background(), fsys, file)
+  if err == errBadGzip {
+    return fail("malformed gzip")
+  }
+  if err == errTooLarge {
+    return skip("too large")
+  }
+  if err != nil {
+    return fail("read error: " + err.Error())
+  }
+  if isBinary(content) {
+    return skip("binary")
+  }
+  doc := &Document{
+    Path: entry.Path,
+    Modified: info.ModTime(),
+    Size: info.Size(),
+    Keywords: []string{},
+    Backlinks: []string{},
+    OutboundLinks: []string{},
+    TOC: []TOCEntry{},
+  }
+  var text string
+  if isPlainTextPath(file) {
+    // Plain text has no metadata, links or headings to collect.
+    text = plainText(content)
+  } else {
+    node, err := html.Parse(strings.NewReader(string(content)))
+    if err != nil {
+      return fail("parse error: " + err.Error())
+    }
+    collectMetadata(node, doc)
+    if doc.Title == "" {
+      for _, heading := range doc.TOC {
+        if heading.Level == 1 {
+          doc.Title = cleanTitle(heading.Text)
+          break
+        }
+      }
+    }
+    body := findElement(node, "body")
+    if body == nil {
+      body = node
+    }
+    text = documentText(body)
+  }
+  entry.Title = doc.Title
+
+  words := strings.Fields(text)
+  doc.WordCount = len(words)
+  doc.ReadingTimeMin = (doc.WordCount + wordsPerMinute - 1) / wordsPerMinute
+  text = strings.Join(words, " ")
+  if doc.Snippet = truncateRunes(text, snippetLength); doc.Snippet != text {
+    doc.Snippet = strings.TrimSpace(doc.Snippet) + currentConfig().SnippetEllipsis
+  }
+  doc.terms = termCounts(words)
+  return doc, entry
+}
+
+// isHiddenPath reports whether any segment of p starts with a dot.
+func isHiddenPath(p string) bool {
+  for _, segment := range strings.Split(p, "/") {
+    if strings.HasPrefix(segment, ".") {
+      return true
+    }
+  }
+  return false
+}
+
+// isBinary sniffs the start of the content the same way net/http does and
+// reports whether it is something other than text.
+func isBinary(content []byte) bool {
+  return !strings.HasPrefix(http.DetectContentType(content), "text/")
+}
+
+func collectMetadata(n *html.Node, doc *Document) {
+  if n.Type == html.ElementNode {
+    switch n.Data {
+    case "title":
+      if doc.Title == "" {
+        doc.Title = cleanTitle(extractText(n))
+      }
+    case "meta":
+      name := strings.ToLower(attr(n, "name"))
+      content := strings.TrimSpace(attr(n, "content"))
+      switch name {
+      case "description":
+        doc.Description = content
+      case "author":
+        doc.Author = content
+      case "keywords":
+        for _, keyword := range strings.Split(content, ",") {
+          if keyword = strings.TrimSpace(keyword); keyword != "" {
+            doc.Keywords = append(doc.Keywords, keyword)
+          }
+        }
+      }
+    case "a":
+      if link := resolveLink(doc.Path, attr(n, "href")); link != "" {
+        doc.OutboundLinks = append(doc.OutboundLinks, link)
+      }
+    case "h1", "h2", "h3", "h4", "h5", "h6":
+      // Markup inside a heading is flattened to its text, with a space
+      // between elements so <br> doesn't glue words together.
+      text := strings.Join(strings.Fields(documentText(n)), " ")
+      if text != "" {
+        doc.TOC = append(doc.TOC, TOCEntry{Level: int(n.Data[1] - '0'), Text: text, ID: attr(n, "id")})
+      }
+    }
+  }
+  for c := n.FirstChild; c != nil; c = c.NextSibling {
+    collectMetadata(c, doc)
+  }
+}
+
+// documentText is like extractText but keeps text nodes apart, so that
+// words from neighbouring elements are not glued together.
+func documentText(n *html.Node) string {
+  var sb strings.Builder
+  var walk func(*html.Node)
+  walk = func(n *html.Node) {
+    if n.Type == html.TextNode {
+      sb.WriteString(n.Data)
+      sb.WriteString(" ")
+      return
+    }
+    if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+      return
+    }
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+      walk(c)
+    }
+  }
+  walk(n)
+  return sb.String()
+}
+
+func findElement(n *html.Node, name string) *html.Node {
+  if n.Type == html.ElementNode && n.Data == name {
+    return n
+  }
+  for c := n.FirstChild; c != nil; c = c.NextSibling {
+    if found := findElement(c, name); found != nil {
+      return found
+    }
+  }
+  return nil
+}
+
+func attr(n *html.Node, key string) string {
+  for _, a := range n.Attr {
+    if a.Key == key {
+      return a.Val
+    }
+  }
+  return ""
+}
+
+// resolveLink returns links to other wiki pages relative to the wiki root,
+// and absolute URLs unchanged.
+func resolveLink(from, href string) string {
+  href = strings.TrimSpace(href)
+  if href == "" || strings.HasPrefix(href, "#") {
+    return ""
+  }
+  if strings.Contains(href, "://") || strings.HasPrefix(href, "mailto:") {
+    return href
+  }
+  if i := strings.IndexAny(href, "?#"); i >= 0 {
+    href = href[:i]
+  }
+  if strings.HasPrefix(href, "/") {
+    return strings.TrimPrefix(strings.TrimPrefix(path.Clean(href), "/static"), "/")
+  }
+  return strings.TrimPrefix(path.Join(path.Dir(from), href), "/")
+}
+
+const maxTitleLength = 120
+
+// cleanTitle strips any markup left in a title (the <title> element's
+// content is not parsed as HTML), collapses whitespace and truncates it.
+func cleanTitle(s string) string {
+  if strings.Contains(s, "<") {
+    if node, err := html.Parse(strings.NewReader(s)); err == nil {
+      s = documentText(node)
+    }
+  }
+  s = strings.Join(strings.Fields(s), " ")
+  if t := truncateRunes(s, maxTitleLength); t != s {
+    return strings.TrimSpace(t) + currentConfig().SnippetEllipsis
+  }
+  return s
+}
+
+func truncateRunes(s string, n int) string {
+  runes := []rune(s)
+  if len(runes) <= n {
+    return s
+  }
+  return string(runes[:n])
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+  "time"
+)
+
+// manyDocs returns n pages, each mentioning "common" and its own number.
+func manyDocs(n int) map[string]string {
+  files := map[string]string{}
+  for i := 0; i < n; i++ {
+    files[fmt.Sprintf("dir%d/page%d.html", i%5, i)] = fmt.Sprintf("<title>Page %d</title><p>common text, page number%d</p>", i, i)
+  }
+  return files
+}
+
+// Run with -race.
+func TestConcurrentSearchesDuringReindex(t *testing.T) {
+  cfg := serveDocs(t, manyDocs(20), nil)
+
+  var wg sync.WaitGroup
+  done := make(chan struct{})
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    for i := 0; ; i++ {
+      select {
+      case <-done:
+        return
+      default:
+      }
+      rebuildIndex()
+      // Swap the config too, as POST /admin/config does.
+      next := *cfg
+      next.MaxResults = 1000 + i%2
+      setConfig(&next)
+    }
+  }()
+
+  errs := make(chan string, 100)
+  var searches sync.WaitGroup
+  for i := 0; i < 100; i++ {
+    searches.Add(1)
+    go func(i int) {
+      defer searches.Done()
+      target := "/api/search?q=common"
+      if i%2 == 1 {
+        target = fmt.Sprintf("/api/search?q=number%d", i%20)
+      }
+      if w := get(handleAPISearch, target); w.Code != http.StatusOK {
+        errs <- fmt.Sprintf("%s: status %d", target, w.Code)
+      }
+      if w := get(handlePage, fmt.Sprintf("/api/page?path=dir%d/page%d.html", i%5, i%20)); w.Code != http.StatusOK {
+        errs <- fmt.Sprintf("page %d: status %d", i%20, w.Code)
+      }
+    }(i)
+  }
+  searches.Wait()
+  close(done)
+  wg.Wait()
+  close(errs)
+  for err := range errs {
+    t.Error(err)
+  }
+}
+
+func TestBinaryFilesSkipped(t *testing.T) {
+  png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01binary common"
+  serveDocs(t, map[string]string{
+    "image.html": png,
+    "page.html": "<p>common text</p>",
+  }, nil)
+
+  var entry FileEntry
+  for _, e := range currentIndex().Files {
+    if e.Path == "image.html" {
+      entry = e
+    }
+  }
+  if !entry.Skipped || entry.Reason != "binary" {
+    t.Errorf("image.html entry = %+v, want skipped as binary", entry)
+  }
+  if _, ok := currentIndex().Docs["image.html"]; ok {
+    t.Error("image.html was indexed")
+  }
+
+  var resp searchResponse
+  w := get(handleAPISearch, "/api/search?q=common")
+  if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+    t.Fatal(err)
+  }
+  if resp.Total != 1 || resp.Results[0].Path != "page.html" || resp.Errors != 0 {
+    t.Errorf("search = %+v, want page.html alone and no errors", resp)
+  }
+}
+
+// TestIndexConcurrency runs searches against a background reindexer that
+// keeps changing the documents. Run it with -race.
+func TestIndexConcurrency(t *testing.T) {
+  cfg := serveDocs(t, manyDocs(30), nil)
+  duration := 2 * time.Second
+  if testing.Short() {
+    duration = 200 * time.Millisecond
+  }
+  stop := make(chan struct{})
+  time.AfterFunc(duration, func() { close(stop) })
+  stopped := func() bool {
+    select {
+    case <-stop:
+      return true
+    default:
+      return false
+    }
+  }
+
+  var wg sync.WaitGroup
+  wg.Add(1)
+  go func() {
+    defer wg.Done()
+    extra := filepath.Join(cfg.Directory, "extra.html")
+    for i := 0; !stopped(); i++ {
+      if i%2 == 0 {
+        os.WriteFile(extra, []byte(fmt.Sprintf("<p>common extra %d</p>", i)), 0644)
+      } else {
+        os.Remove(extra)
+      }
+      rebuildIndex()
+    }
+  }()
+
+  errs := make(chan error, 50)
+  for i := 0; i < 50; i++ {
+    wg.Add(1)
+    go func(i int) {
+      defer wg.Done()
+      for !stopped() {
+        if w := get(handleAPISearch, "/api/search?q=common&sort=score"); w.Code != http.StatusOK {
+          errs <- fmt.Errorf("search: status %d", w.Code)
+          return
+        }
+        idx := currentIndex()
+        for _, p := range idx.Paths {
+          if idx.Docs[p] == nil {
+            errs <- fmt.Errorf("%s is listed but not indexed", p)
+            return
+          }
+        }
+        if w := get(handleTree, "/api/tree?prefix=dir1"); w.Code != http.StatusOK {
+          errs <- fmt.Errorf("tree: status %d", w.Code)
+          return
+        }
+      }
+    }(i)
+  }
+  wg.Wait()
+  close(errs)
+  for err := range errs {
+    t.Error(err)
+  }
+}
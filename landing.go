@@ -0,0 +1,75 @@
+package main
+
+import (
+  "html/template"
+  "os"
+  "path/filepath"
+  "sort"
+  "sync"
+  "time"
+)
+
+const landingRecentCount = 15
+const landingCacheTTL = 30 * time.Second
+
+type landingDoc struct {
+  Title string
+  Path string
+  URL string
+  Modified time.Time
+}
+
+type landingPage struct {
+  pageData
+  Recent []landingDoc
+}
+
+var landingTemplate *template.Template
+
+var landingCache struct {
+  sync.Mutex
+  index *Index
+  built time.Time
+  docs []landingDoc
+}
+
+// recentlyUpdated returns the most recently modified documents. The list
+// is rebuilt from the index at most every landingCacheTTL, or sooner when
+// a new index snapshot has been published.
+func recentlyUpdated() []landingDoc {
+  idx := currentIndex()
+  landingCache.Lock()
+  defer landingCache.Unlock()
+  if landingCache.index == idx && time.Since(landingCache.built) < landingCacheTTL {
+    return landingCache.docs
+  }
+
+  all := make([]*Document, 0, len(idx.Docs))
+  for _, doc := range idx.Docs {
+    all = append(all, doc)
+  }
+  sort.Slice(all, func(i, j int) bool { return all[i].Modified.After(all[j].Modified) })
+  if len(all) > landingRecentCount {
+    all = all[:landingRecentCount]
+  }
+  docs := []landingDoc{}
+  for _, doc := range all {
+    docs = append(docs, landingDoc{Title: doc.Title, Path: doc.Path, URL: staticLinkEscaped(doc.Path), Modified: doc.Modified})
+  }
+  landingCache.index = idx
+  landingCache.built = time.Now()
+  landingCache.docs = docs
+  return docs
+}
+
+// customSearchForm reports whether assetsDir has its own search.html, which
+// is then served in place of the landing page.
+func customSearchForm() bool {
+  dir := currentConfig().AssetsDir
+  if dir == "" {
+    return false
+  }
+  info, err := os.Stat(filepath.Join(dir, "search.html"))
+  return err == nil && info.Mode().IsRegular()
+}
+
@@ -1,220 +1,555 @@
 package main
 
 import (
+  "bytes"
+  "context"
+  "flag"
+  "io"
+  "io/fs"
   "fmt"
   "net/http"
   "os"
+  "path"
   "path/filepath"
+  "sort"
   "strings"
-  "io/ioutil"
+  "time"
   "encoding/json"
   "net"
+  "crypto/rand"
+  "encoding/hex"
   "golang.org/x/net/html"
-  "html/template"
 )
 
-type Config struct {
-  Port string `json:"port"`
-  IPRanges []string `json: "IPRanges"`
-  Directory string `json:"directory"`
+func main() {
+  var port, directory string
+  showVersion := flag.Bool("version", false, "print version information and exit")
+  flag.StringVar(&configPath, "config", configPath, "path to config file (.json, .yaml, .yml or .toml)")
+  flag.StringVar(&port, "port", "", "port to listen on, overriding the config")
+  flag.StringVar(&directory, "directory", "", "directory to serve, overriding the config")
+  flag.Parse()
+  if *showVersion {
+    fmt.Println(buildInfo())
+    return
+  }
+  fmt.Println(buildInfo())
+
+  cfg, err := loadConfig(configPath)
+  if err != nil {
+    fmt.Println("Invalid config: ", err)
+    os.Exit(1)
+  }
+  applyEnvOverrides(&cfg)
+  if port != "" {
+    cfg.Port = port
+  }
+  if directory != "" {
+    cfg.Directory = directory
+  }
+  if err := validateConfig(cfg); err != nil {
+    fmt.Println("Invalid config: ", err)
+    os.Exit(1)
+  }
+  setConfig(&cfg)
+  warnIPCheckDisabled(cfg)
+
+  applyRuntimeSettings(&cfg, nil)
+  go saveAnalyticsPeriodically(analyticsSaveInterval)
+
+  reloadTemplates()
+  go reloadTemplatesOnSIGHUP()
+
+  // The first index is built in the background, so the server can answer
+  // health checks meanwhile. Shutting down mid-build just abandons it.
+  go func() {
+    rebuildIndex()
+    fmt.Println("Index ready")
+  }()
+  go reindexPeriodically()
+
+  ln, err := listen(cfg)
+  if err != nil {
+    fmt.Println("Error: ", err)
+    os.Exit(1)
+  }
+  fmt.Println("Listening on", ln.Addr(), "serving", describeDocs(cfg.Directory))
+  err = serve(&http.Server{Handler: newServeMux(docsFS(cfg.Directory))}, ln)
+  if err := saveAnalytics(); err != nil {
+    fmt.Println("Error saving analytics: ", err)
+  }
+  if err != nil {
+    fmt.Println("Error: ", err)
+    os.Exit(1)
+  }
 }
 
-type Node struct {
-  Path string
-  Children []*Node
+// newServeMux registers every route on a fresh mux, with /static/ serving
+// files from docs and each configured mount under its prefix.
+func newServeMux(docs fs.FS) *http.ServeMux {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/", handleSearch)
+  mux.HandleFunc("/api/page", handlePage)
+  mux.HandleFunc("/api/search", handleAPISearch)
+  mux.HandleFunc("/api/search/batch", handleAPISearchBatch)
+  mux.HandleFunc("/api/text", handleText)
+  mux.HandleFunc("/api/tree", handleTree)
+  mux.HandleFunc("/api/related", handleAPIRelated)
+  mux.HandleFunc("/version", handleVersion)
+  mux.HandleFunc("/healthz", handleHealthz)
+  mux.HandleFunc("/api/files", handleFiles)
+  mux.HandleFunc("/admin/recent", handleRecent)
+  mux.HandleFunc("/admin/reindex", handleReindex)
+  mux.HandleFunc("/admin/progress", handleReindexProgress)
+  mux.HandleFunc("/admin/stats", handleStats)
+  mux.HandleFunc("/metrics", handleMetrics)
+  mux.HandleFunc("/admin/config", handleAdminConfig)
+  mux.HandleFunc("/fragment/search", handleFragmentSearch)
+  mux.HandleFunc("/fragment/suggest", handleFragmentSuggest)
+  mux.HandleFunc("/ws/search", handleSearchWS)
+  mux.HandleFunc("/sitemap", handleSitemap)
+  mux.HandleFunc("/titles", handleTitles)
+  mux.HandleFunc("/tags", handleTags)
+  mux.HandleFunc("/related", handleRelated)
+  mux.HandleFunc("/random", handleRandom)
+  mux.HandleFunc("/go", handleGo)
+  mux.HandleFunc("/view", handleView)
+  mux.HandleFunc("/history/clear", handleClearHistory)
+  mux.HandleFunc("/dashboard", handleDashboard)
+  mux.HandleFunc("/browse/", handleBrowse)
+  mux.HandleFunc("/sitemap.xml", handleSitemapXML)
+  mux.HandleFunc("/opensearch.xml", handleOpenSearch)
+  mux.HandleFunc("/style.css", handleStyle)
+  mux.HandleFunc("/favicon.ico", handleFavicon)
+  mux.HandleFunc("/logo", handleLogo)
+  mux.Handle("/static/", http.StripPrefix("/static/", StaticCacheMiddleware(staticMaxAge)(staticHandler(docs))))
+  for _, m := range currentConfig().Mounts {
+    mux.Handle(m.Prefix, mountHandler(m))
+  }
+  return mux
 }
 
-var config Config
+func handleStyle(w http.ResponseWriter, r *http.Request) {
+  serveAsset(w, r, "style.css")
+}
 
-func main() {
-  file, _ := os.Open("config.json")
-  defer file.Close()
-  decoder := json.NewDecoder(file)
-  err := decoder.Decode(&config)
+// faviconMaxAge is long: browsers ask for the icon on every page, and it
+// only changes with a new build or assetsDir override.
+const faviconMaxAge = 7 * 24 * time.Hour
+
+func handleFavicon(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(faviconMaxAge.Seconds())))
+  serveAsset(w, r, "favicon.ico")
+}
+
+// logoURL is where pages load the logo from: /logo when logoPath names a
+// local file, otherwise logoURL as configured.
+func logoURL(c *Config) string {
+  if c.LogoPath != "" {
+    return "/logo"
+  }
+  return c.LogoURL
+}
+
+// handleLogo serves the logoPath file, cached like /static/ responses.
+func handleLogo(w http.ResponseWriter, r *http.Request) {
+  cfg := currentConfig()
+  if cfg.LogoPath == "" {
+    http.NotFound(w, r)
+    return
+  }
+  f, err := os.Open(cfg.LogoPath)
   if err != nil {
-    fmt.Println("Error: ", err)
+    fmt.Println("Error opening logo: ", err)
+    http.NotFound(w, r)
+    return
   }
+  defer f.Close()
+  info, err := f.Stat()
+  if err != nil || !info.Mode().IsRegular() {
+    http.NotFound(w, r)
+    return
+  }
+  serve := func(w http.ResponseWriter, r *http.Request) {
+    http.ServeContent(w, r, filepath.Base(cfg.LogoPath), info.ModTime(), f)
+  }
+  StaticCacheMiddleware(staticMaxAge)(http.HandlerFunc(serve)).ServeHTTP(w, r)
+}
 
-  http.HandleFunc("/", handleSearch)
-  http.HandleFunc("/style.css", handleStyle)
-  http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(config.Directory))))
+// luckyResult is the result with the most occurrences of the query, for
+// ?lucky=1. A tie for first place has no clear winner and reports false.
+func luckyResult(results []SearchResult) (SearchResult, bool) {
+  var top SearchResult
+  tied := false
+  for i, result := range results {
+    switch {
+    case i == 0 || result.Score > top.Score:
+      top, tied = result, false
+    case result.Score == top.Score:
+      tied = true
+    }
+  }
+  return top, len(results) > 0 && !tied
+}
 
-  fmt.Println("Listening on port", config.Port)
-  http.ListenAndServe(":" + config.Port, nil)
+// clientIP returns the peer address, or for unix socket peers (which have no
+// address) the one forwarded by the local proxy. It is empty when unknown.
+func clientIP(r *http.Request) string {
+  ip, _, err := net.SplitHostPort(r.RemoteAddr)
+  if err == nil && ip != "" {
+    return ip
+  }
+  if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+    return ip
+  }
+  forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+  return strings.TrimSpace(forwarded[0])
 }
 
-func handleStyle(w http.ResponseWriter, r *http.Request) {
-  http.ServeFile(w, r, "style.css")
+// warnIPCheckDisabled logs a warning that can't be missed when
+// disableIPCheck is set. It is meant for local development only.
+func warnIPCheckDisabled(c Config) {
+  if !c.DisableIPCheck {
+    return
+  }
+  fmt.Println("**************************************************************")
+  fmt.Println("WARNING: disableIPCheck is set, ALL CLIENTS ARE ALLOWED.")
+  fmt.Println("IP access control is off. Never use this outside development.")
+  fmt.Println("**************************************************************")
 }
 
-func handleSearch(w http.ResponseWriter, r *http.Request) {
-  w.Header().Set("Content-Type", "text/html; charset=utf-8")
-  ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-  if !isIPInRange(ip, config.IPRanges) {
-    http.Error(w, "Forbidden", http.StatusForbidden)
+func checkAccess(w http.ResponseWriter, r *http.Request) bool {
+  return checkAccessRanges(w, r, currentConfig().IPRanges)
+}
+
+// checkAccessRanges is checkAccess against ranges instead of the global
+// IP ranges.
+func checkAccessRanges(w http.ResponseWriter, r *http.Request, ranges []IPRange) bool {
+  cfg := currentConfig()
+  if cfg.DisableIPCheck {
+    return true
+  }
+  ip := clientIP(r)
+  if ip == "" {
+    if cfg.AllowUnknownPeer {
+      return true
+    }
+    http.Error(w, translate(requestLanguage(r), "error.forbidden"), http.StatusForbidden)
+    fmt.Println("Forbidden access for unknown peer")
+    return false
+  }
+  match := matchIPRange(ip, ranges)
+  if match == nil {
+    http.Error(w, translate(requestLanguage(r), "error.forbidden"), http.StatusForbidden)
     fmt.Println("Forbidden access for: ", ip)
+    return false
+  }
+  fmt.Println("Access for:", ip, "("+match.Label()+")", r.URL.Path)
+  return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+  w.Header().Set("Content-Type", "application/json; charset=utf-8")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+  writeJSON(w, status, map[string]string{"error": message})
+}
+
+// logSearchError logs err under a new request ID and returns the ID, also
+// set as X-Request-ID. Responses quote only the ID, so file paths and
+// system errors stay in the log.
+func logSearchError(w http.ResponseWriter, err error) string {
+  b := make([]byte, 8)
+  rand.Read(b)
+  id := hex.EncodeToString(b)
+  w.Header().Set("X-Request-ID", id)
+  fmt.Println("Error searching files [", id, "]: ", err)
+  return id
+}
+
+func handlePage(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+
+  p := strings.TrimPrefix(path.Clean("/"+r.URL.Query().Get("path")), "/")
+  doc, ok := currentIndex().Docs[p]
+  if !ok {
+    writeJSONError(w, http.StatusNotFound, "page not found")
     return
   }
+  writeJSON(w, http.StatusOK, doc)
+}
+
+// responseWriter remembers whether the response has been started, so error
+// paths don't try to send a second status after a partial body.
+type responseWriter struct {
+  http.ResponseWriter
+  wroteHeader bool
+}
 
-  query := r.URL.Query().Get("q")
-  if query == "" {
-    http.ServeFile(w, r, "search.html")
+func (w *responseWriter) WriteHeader(status int) {
+  if w.wroteHeader {
     return
   }
+  w.wroteHeader = true
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+  if !w.wroteHeader {
+    w.WriteHeader(http.StatusOK)
+  }
+  return w.ResponseWriter.Write(b)
+}
+
+func (w *responseWriter) Flush() {
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+  return w.ResponseWriter
+}
 
-  files, err := searchFiles(config.Directory, "*.html")
+// Error sends an error response unless something was already written.
+func (w *responseWriter) Error(message string, status int) {
+  if w.wroteHeader {
+    fmt.Println("Response already started, dropping error: ", message)
+    return
+  }
+  http.Error(w, message, status)
+}
+
+func handleSearch(rw http.ResponseWriter, r *http.Request) {
+  w := &responseWriter{ResponseWriter: rw}
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if !checkAccess(w, r) {
+    return
+  }
+  // "/" is the catch-all pattern; only the root itself is the search page.
+  if r.URL.Path != "/" {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_page")
+    return
+  }
+
+  if refuseWhileIndexing(w, r, false) {
+    return
+  }
+
+  // An allowed client without a query or tag gets the landing page; a
+  // query that is present but invalid is a client error. A query string
+  // that doesn't parse is checked first, as its ?q= may have been lost.
+  if err := checkQueryEncoding(r); err != nil {
+    renderError(w, r, http.StatusBadRequest, "error.bad_query", queryErrorMessage(requestLanguage(r), err))
+    return
+  }
+  if _, ok := r.URL.Query()["q"]; !ok && r.URL.Query().Get("tag") == "" {
+    serveSearchForm(w, r)
+    return
+  }
+  query, err, status := validateSearchParams(r)
   if err != nil {
-    http.Error(w, "Error searching files", http.StatusInternalServerError)
+    renderError(w, r, status, "error.bad_query", queryErrorMessage(requestLanguage(r), err))
     return
   }
 
-  var results []string
-  query = strings.ToLower(query) // case insensitive search
-  for _, file := range files {
-    content, err := ioutil.ReadFile(file)
-    if err != nil {
-      http.Error(w, "Error reading file", http.StatusInternalServerError)
-      return
-    }
-    doc, err := html.Parse(strings.NewReader(string(content)))
+  ctx := r.Context()
+  cfg := currentConfig()
+  opts := searchOptionsFor(r)
+  opts.Stats = &scanStats{}
+  lucky := r.URL.Query().Get("lucky") == "1"
+  view := resultsView(r)
+  opts.Score = opts.Score || lucky || view == viewGrouped
+  rememberView(w, r)
+  rememberSearch(w, r, query)
+  if streamsResults(r) {
+    streamSearch(ctx, w, r, query, opts)
+    return
+  }
+  cacheKey := queryCacheKey(query, opts)
+  results, cached := queries.Load().Get(cacheKey)
+  if !cached {
+    err = searchDocuments(ctx, docsFS(cfg.Directory), query, opts, func(result SearchResult) error {
+      results = append(results, result)
+      return nil
+    })
     if err != nil {
-      http.Error(w, "Error parsing HTML", http.StatusInternalServerError)
+      if ctx.Err() == nil {
+        id := logSearchError(w, err)
+        w.Error(translate(requestLanguage(r), "error.searching", id), http.StatusInternalServerError)
+      }
       return
     }
-    text := extractText(doc)
-    if strings.Contains(strings.ToLower(text), query) {
-      results = append(results, "/static/"+strings.ReplaceAll(strings.TrimPrefix(file, config.Directory), "\\", "/"))
+    if opts.Stats.FileErrors > 0 {
+      fmt.Println("Skipped", opts.Stats.FileErrors, "unreadable files searching for", query)
     }
+    queries.Load().Add(cacheKey, results)
+  }
+
+  recent.Load().Add(RecentQuery{Query: query, Time: time.Now(), Results: len(results), IP: clientIP(r)})
+  usage.Load().AddQuery(query)
+
+  // A feed with no entries is still a valid feed.
+  if r.URL.Query().Get("format") == "atom" {
+    writeAtomFeed(w, r, query, results)
+    return
   }
-  
   if len(results) == 0 {
-    http.Error(w, "No results found", http.StatusNotFound)
+    renderNoResults(w, r, query)
+    return
+  }
+  if top, ok := luckyResult(results); lucky && ok {
+    http.Redirect(w, r, staticLinkEscaped(top.Path), http.StatusFound)
     return
   }
 
-  root := &Node{}
-  for _, result := range results {
-    parts := strings.Split(result, "/")
-    node := root
-    for _, part := range parts {
-      found := false
-      for _, child := range node.Children {
-        if child.Path == part {
-          node = child
-          found = true
-          break
-        }
-      }
-      if !found {
-        newNode := &Node{Path: part}
-        node.Children = append(node.Children, newNode)
-        node = newNode
-      }
+  // Results pages only change when a matching file does, so clients can
+  // revalidate against the newest result's mtime. HTTP dates have whole
+  // seconds. Built-in sample pages have no mtime and are not revalidated.
+  newest := newestMtime(results).Truncate(time.Second)
+  if !newest.IsZero() {
+    if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !newest.After(since) {
+      w.WriteHeader(http.StatusNotModified)
+      return
     }
+    w.Header().Set("Last-Modified", newest.UTC().Format(http.TimeFormat))
   }
 
-  type renderFunc func(*Node, string) template.HTML
-  var renderNode renderFunc
-  renderNode = func(node *Node, fullPath string) template.HTML {
-    if len(fullPath) > 0 {
-      fullPath += "/"
+  if showTOC(r) {
+    docs := currentIndex().Docs
+    for i := range results {
+      results[i].TOC = resultTOC(docs[results[i].Path])
     }
-    fullPath += node.Path
-    if len(node.Children) == 0 {
-      return template.HTML(fmt.Sprintf(`<li><a href="./%s">%s</a></li>`, fullPath, node.Path))
-    }
-    var children string
-    for _, child := range node.Children {
-      children += string(renderNode(child, fullPath))
-    }
-    return template.HTML(fmt.Sprintf(`<li>%s<ul>%s</ul></li>`, node.Path, children))
   }
+  var links []string
+  leaves := map[string]leafInfo{}
+  for _, result := range results {
+    link := staticLink(result.Path)
+    links = append(links, link)
+    leaves[link] = result.leaf(query)
+  }
+  root := buildTree(links, leaves)
+  sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+  sortByFields(results, opts.Sort)
 
-  tmpl := template.Must(template.New("results").Funcs(template.FuncMap{
-    "renderNode": renderNode,
-  }).Parse(`
-  <!DOCTYPE html>
-  <html>
-  <head>
-    <title>Результаты поиска</title>
-    <style>
-      body {
-        display: flex;
-        flex-direction: column;
-        justify-content: center;
-        align-items: center;
-        #height: 100vh;
-        margin: 0;
-      }
-      h1 {
-        margin-bottom: 20px;
-      }
-      ul {
-        text-align: left;
-      }
-      a:hover {
-        color: #00f;
-      }
-    </style>
-    <link rel="stylesheet" href="style.css"></link>
-  </head>
-  <body>
-    <h1>Результаты поиска</h1>
-    <ul>
-    {{range .Children}}{{renderNode . ""}}{{end}}
-    </ul>
-  </body>
-  </html>
-  `))
-
-  err = tmpl.Execute(w, struct{
-    Children []*Node
-    Path string
-  }{
+  var groups []ResultGroup
+  groupURL := withParam(r, "group", "1")
+  if r.URL.Query().Get("group") == "1" {
+    groups = groupResults(results, query)
+    groupURL = withParam(r, "group", "")
+  }
+  var sections []ResultSection
+  if view == viewGrouped {
+    sections = sectionResults(results, opts.Sort)
+  }
+
+  lang := requestLanguage(r)
+  tmpl := resultTemplate(r.URL.Query().Get("tmpl"))
+  err = tmpl.Execute(w, resultsPage{
+    Lang: lang,
+    SiteTitle: cfg.SiteTitle,
+    LogoURL: logoURL(cfg),
+    Title: siteTitle(lang),
+    Query: query,
+    Dir: opts.Dir,
+    Ext: opts.Ext,
+    Tag: opts.Tag,
+    Sort: r.URL.Query().Get("sort"),
+    In: opts.In,
+    TaglessURL: withParam(r, "tag", ""),
     Children: root.Children,
-    Path: "",
+    Results: results,
+    Count: len(results),
+    View: view,
+    TreeURL: withParam(r, "view", viewTree),
+    FlatURL: withParam(r, "view", viewFlat),
+    GroupedURL: withParam(r, "view", viewGrouped),
+    Groups: groups,
+    GroupURL: groupURL,
+    Sections: sections,
+    Searches: withSearch(r, query),
   })
   if err != nil {
-    http.Error(w, "Error generating HTML", http.StatusInternalServerError)
+    fmt.Println("Error generating HTML: ", err)
+    w.Error(translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
     return
   }
 }
 
+// extractText concatenates the text of n and its descendants, leaving out
+// script and style contents. A nil node has no text. html.Parse passes
+// invalid UTF-8 through, so it is replaced here.
 func extractText(n *html.Node) string {
-  if n.Type == html.TextNode {
-    return n.Data
+  var sb strings.Builder
+  var walk func(*html.Node)
+  walk = func(n *html.Node) {
+    if n.Type == html.TextNode {
+      sb.WriteString(n.Data)
+      return
+    }
+    if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+      return
+    }
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+      walk(c)
+    }
   }
-  var text string
-
-  for c := n.FirstChild; c != nil; c = c.NextSibling {
-    text += extractText(c)
+  if n != nil {
+    walk(n)
   }
-  return text
+  return strings.ToValidUTF8(sb.String(), "\uFFFD")
 }
 
 func isIPInRange(ip string, ranges []string) bool {
+  var parsed []IPRange
   for _, r := range ranges {
-    _, ipNet, _ := net.ParseCIDR(r)
+    parsed = append(parsed, IPRange{CIDR: r})
+  }
+  return matchIPRange(ip, parsed) != nil
+}
+
+// matchIPRange returns the first range containing ip, or nil.
+func matchIPRange(ip string, ranges []IPRange) *IPRange {
+  for i, r := range ranges {
+    _, ipNet, err := net.ParseCIDR(r.CIDR)
+    if err != nil || ipNet == nil {
+      fmt.Println("Invalid IP range in config: ", r.CIDR)
+      continue
+    }
     if ipNet.Contains(net.ParseIP(ip)) {
-      return true
+      return &ranges[i]
     }
   }
-  return false
+  return nil
 }
 
-func searchFiles(root, pattern string) ([]string, error) {
+var searchPatterns = []string{"*.html", "*.html.gz", "*.txt", "*.md"}
+
+// searchFiles lists the files in fsys whose names match one of patterns,
+// as slash-separated paths relative to its root.
+func searchFiles(ctx context.Context, fsys fs.FS, patterns []string) ([]string, error) {
   var matches []string
-  err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+  err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
     if err != nil {
       return err
     }
-    if info.IsDir() {
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+    if d.IsDir() {
       return nil
     }
-    if matched, err := filepath.Match(pattern, filepath.Base(path)); err != nil {
-      return err
-    } else if matched {
-      matches = append(matches, path)
+    for _, pattern := range patterns {
+      if matched, err := filepath.Match(pattern, d.Name()); err != nil {
+        return err
+      } else if matched {
+        matches = append(matches, path)
+        break
+      }
     }
     return nil
   })
@@ -224,10 +559,48 @@ func searchFiles(root, pattern string) ([]string, error) {
   return matches, nil
 }
 
-func readFile(path string) string {
-  file, err := ioutil.ReadFile(path)
+const readChunkSize = 32 * 1024
+
+// readFileCtx reads the file in chunks and gives up as soon as ctx is done.
+func readFileCtx(ctx context.Context, fsys fs.FS, path string) ([]byte, error) {
+  f, err := fsys.Open(path)
   if err != nil {
-    fmt.Println(err)
+    return nil, err
   }
-  return string(file)
+  defer f.Close()
+
+  var content []byte
+  buf := make([]byte, readChunkSize)
+  for {
+    select {
+    case <-ctx.Done():
+      return nil, ctx.Err()
+    default:
+    }
+    n, err := f.Read(buf)
+    content = append(content, buf[:n]...)
+    if err == io.EOF {
+      return content, nil
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+}
+
+// ctxReader fails reads once its context is done.
+type ctxReader struct {
+  ctx context.Context
+  r io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+  if err := c.ctx.Err(); err != nil {
+    return 0, err
+  }
+  return c.r.Read(p)
+}
+
+func parseHTML(ctx context.Context, content []byte) (*html.Node, error) {
+  return html.Parse(ctxReader{ctx, bytes.NewReader(content)})
 }
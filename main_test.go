@@ -0,0 +1,459 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io"
+  "io/fs"
+  "math/rand"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "path/filepath"
+  "regexp"
+  "strings"
+  "testing"
+  "testing/fstest"
+  "unicode/utf8"
+  "golang.org/x/net/html"
+)
+
+func TestMain(m *testing.M) {
+  reloadTemplates()
+  os.Exit(m.Run())
+}
+
+// testClient is where httptest.NewRequest says requests come from.
+const testClient = "192.0.2.1"
+
+// useConfig puts the defaults, changed by edit, in effect for the rest of
+// the test. Requests from testClient and loopback are allowed.
+func useConfig(t testing.TB, edit func(c *Config)) *Config {
+  t.Helper()
+  old := currentConfig()
+  cfg := defaultConfig()
+  cfg.IPRanges = []IPRange{{CIDR: "127.0.0.0/8"}, {CIDR: "192.0.2.0/24"}}
+  if edit != nil {
+    edit(&cfg)
+  }
+  setConfig(&cfg)
+  t.Cleanup(func() { setConfig(old) })
+  return &cfg
+}
+
+// writeDocs creates files, keyed by slash-separated path, in a temp
+// directory and returns it.
+func writeDocs(t testing.TB, files map[string]string) string {
+  t.Helper()
+  dir := t.TempDir()
+  for name, text := range files {
+    path := filepath.Join(dir, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+      t.Fatal(err)
+    }
+    if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return dir
+}
+
+// useIndex indexes the configured directory and marks the index ready,
+// restoring the previous index afterwards.
+func useIndex(t testing.TB) *Index {
+  t.Helper()
+  cfg := currentConfig()
+  idx, err := buildIndex(docsFS(cfg.Directory), cfg.MaxFileSize, func(indexed, total int) {})
+  if err != nil {
+    t.Fatal(err)
+  }
+  old, ready := index.Load(), indexReady.Load()
+  setIndex(idx)
+  indexReady.Store(true)
+  t.Cleanup(func() {
+    index.Store(old)
+    indexReady.Store(ready)
+  })
+  return idx
+}
+
+// serveDocs configures files as the documents, with the defaults changed
+// by edit, and indexes them.
+func serveDocs(t testing.TB, files map[string]string, edit func(c *Config)) *Config {
+  t.Helper()
+  dir := writeDocs(t, files)
+  cfg := useConfig(t, func(c *Config) {
+    c.Directory = dir
+    if edit != nil {
+      edit(c)
+    }
+  })
+  useIndex(t)
+  return cfg
+}
+
+// get sends a GET for target to handler and returns the response.
+func get(handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+  w := httptest.NewRecorder()
+  handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+  return w
+}
+
+func TestReadMissingFile(t *testing.T) {
+  docs := docsFS(writeDocs(t, map[string]string{"a.html": "<p>a</p>"}))
+  for _, name := range []string{"missing.html", "missing.html.gz", "dir/missing.html"} {
+    if _, err := readFileCtx(context.Background(), docs, name); !errors.Is(err, fs.ErrNotExist) {
+      t.Errorf("readFileCtx(%q) error = %v, want fs.ErrNotExist", name, err)
+    }
+    if _, err := readDocument(context.Background(), docs, name); !errors.Is(err, fs.ErrNotExist) {
+      t.Errorf("readDocument(%q) error = %v, want fs.ErrNotExist", name, err)
+    }
+  }
+  content, err := readFileCtx(context.Background(), docs, "a.html")
+  if err != nil || string(content) != "<p>a</p>" {
+    t.Errorf("readFileCtx(a.html) = %q, %v", content, err)
+  }
+}
+
+// cancelFS cancels a context when the walk reaches directory dir, and
+// records every directory read.
+type cancelFS struct {
+  fstest.MapFS
+  dir string
+  cancel context.CancelFunc
+  read []string
+}
+
+func (c *cancelFS) ReadDir(name string) ([]fs.DirEntry, error) {
+  c.read = append(c.read, name)
+  if name == c.dir {
+    c.cancel()
+  }
+  return c.MapFS.ReadDir(name)
+}
+
+func TestSearchFilesCancelledMidWalk(t *testing.T) {
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  docs := &cancelFS{MapFS: fstest.MapFS{
+    "a/1.html": {Data: []byte("<p>1</p>")},
+    "b/2.html": {Data: []byte("<p>2</p>")},
+    "c/3.html": {Data: []byte("<p>3</p>")},
+  }, dir: "b", cancel: cancel}
+
+  files, err := searchFiles(ctx, docs, searchPatterns)
+  if err != context.Canceled {
+    t.Fatalf("searchFiles error = %v, want context.Canceled", err)
+  }
+  if files != nil {
+    t.Errorf("searchFiles returned %v with the error", files)
+  }
+  for _, dir := range docs.read {
+    if dir == "c" {
+      t.Error("the walk went on into c after the cancel")
+    }
+  }
+}
+
+func TestReadFileCtxCancelled(t *testing.T) {
+  ctx, cancel := context.WithCancel(context.Background())
+  cancel()
+  docs := fstest.MapFS{"a.html": {Data: []byte("<p>a</p>")}}
+  if _, err := readFileCtx(ctx, docs, "a.html"); err != context.Canceled {
+    t.Errorf("readFileCtx error = %v, want context.Canceled", err)
+  }
+  if _, err := parseHTML(ctx, []byte("<p>a</p>")); err != context.Canceled {
+    t.Errorf("parseHTML error = %v, want context.Canceled", err)
+  }
+}
+
+func TestIsIPInRange(t *testing.T) {
+  tests := []struct {
+    name string
+    ip string
+    ranges []string
+    want bool
+  }{
+    {"IPv4 in range", "192.168.1.20", []string{"10.0.0.0/8", "192.168.1.0/24"}, true},
+    {"IPv4 out of range", "192.168.2.20", []string{"10.0.0.0/8", "192.168.1.0/24"}, false},
+    {"IPv6 in IPv6 range", "2001:db8::1", []string{"2001:db8::/32"}, true},
+    {"IPv4-mapped IPv6 in IPv4 range", "::ffff:10.1.2.3", []string{"10.0.0.0/8"}, true},
+    {"empty ranges", "10.1.2.3", nil, false},
+    {"malformed CIDR skipped", "10.1.2.3", []string{"10.0.0.0/33", "not a cidr", "10.0.0.0/8"}, true},
+    {"loopback", "127.0.0.1", []string{"127.0.0.0/8"}, true},
+    {"multicast", "224.0.0.1", []string{"10.0.0.0/8", "192.168.0.0/16"}, false},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      if got := isIPInRange(tt.ip, tt.ranges); got != tt.want {
+        t.Errorf("isIPInRange(%q, %q) = %v, want %v", tt.ip, tt.ranges, got, tt.want)
+      }
+    })
+  }
+}
+
+func TestDisableIPCheck(t *testing.T) {
+  for _, disabled := range []bool{false, true} {
+    useConfig(t, func(c *Config) {
+      c.IPRanges = []IPRange{{CIDR: "10.0.0.0/8"}}
+      c.DisableIPCheck = disabled
+    })
+    for _, remote := range []string{"10.1.2.3:1234", "203.0.113.9:1234", "[2001:db8::1]:1234", ""} {
+      r := httptest.NewRequest(http.MethodGet, "/", nil)
+      r.RemoteAddr = remote
+      w := httptest.NewRecorder()
+      want := disabled || remote == "10.1.2.3:1234"
+      if got := checkAccess(w, r); got != want {
+        t.Errorf("disableIPCheck=%v, %q: allowed = %v, want %v", disabled, remote, got, want)
+      }
+      if !want && w.Code != http.StatusForbidden {
+        t.Errorf("disableIPCheck=%v, %q: status = %d, want 403", disabled, remote, w.Code)
+      }
+    }
+  }
+}
+
+func TestExtractText(t *testing.T) {
+  deep := strings.Repeat("<div>", 15) + "deep" + strings.Repeat("</div>", 15)
+  tests := []struct {
+    name string
+    input string
+    want string
+  }{
+    {"script", "<p>before</p><script>var x = 1;</script><p>after</p>", "beforeafter"},
+    {"style", "<style>p { color: red }</style><p>styled</p>", "styled"},
+    {"entities", "<p>fish &amp; chips &lt;b&gt; &#8212; caf&eacute;</p>", "fish & chips <b> — café"},
+    {"deep nesting", deep, "deep"},
+    {"nested concatenation", "<p>one <b>two <i>three</i></b> four</p>", "one two three four"},
+    {"empty document", "", ""},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      doc, err := html.Parse(strings.NewReader(tt.input))
+      if err != nil {
+        t.Fatal(err)
+      }
+      if got := extractText(doc); got != tt.want {
+        t.Errorf("extractText(%q) = %q, want %q", tt.input, got, tt.want)
+      }
+    })
+  }
+  t.Run("text node", func(t *testing.T) {
+    if got := extractText(&html.Node{Type: html.TextNode, Data: "plain"}); got != "plain" {
+      t.Errorf("extractText(text node) = %q, want %q", got, "plain")
+    }
+  })
+  t.Run("nil node", func(t *testing.T) {
+    if got := extractText(nil); got != "" {
+      t.Errorf("extractText(nil) = %q, want empty", got)
+    }
+  })
+}
+
+// startServer serves the mux for the configured directory on a random
+// loopback port until the test ends and returns its base URL.
+func startServer(t *testing.T) string {
+  t.Helper()
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  srv := &http.Server{Handler: newServeMux(docsFS(currentConfig().Directory))}
+  go srv.Serve(ln)
+  t.Cleanup(func() { srv.Close() })
+  return "http://" + ln.Addr().String()
+}
+
+func fetch(t *testing.T, url string) (int, string) {
+  t.Helper()
+  resp, err := http.Get(url)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer resp.Body.Close()
+  body, err := io.ReadAll(resp.Body)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return resp.StatusCode, string(body)
+}
+
+func TestHTTPServer(t *testing.T) {
+  cfg := serveDocs(t, map[string]string{
+    "it/printers.html": "<title>Printers</title><p>Printer setup on every floor.</p>",
+    "it/vpn.html": "<title>VPN</title><p>Connecting to the vpn from home.</p>",
+    "odd/a#b %.html": "<title>Odd name</title><p>printer notes with an odd file name</p>",
+  }, nil)
+  base := startServer(t)
+
+  status, body := fetch(t, base+"/?q=printer")
+  if status != http.StatusOK {
+    t.Fatalf("search: status = %d", status)
+  }
+  for _, want := range []string{"Printers", "Odd name", "/view?path=odd%2Fa%23b+%25.html"} {
+    if !strings.Contains(body, want) {
+      t.Errorf("search results do not contain %q", want)
+    }
+  }
+  if regexp.MustCompile(`href="[^"]*a#b`).MatchString(body) {
+    t.Error("a link to a#b %.html is not escaped")
+  }
+  if strings.Contains(body, "vpn.html") {
+    t.Error("vpn.html matched printer")
+  }
+  for _, link := range []string{"/view?path=odd%2Fa%23b+%25.html&q=printer", "/static/odd/a%23b%20%25.html"} {
+    if status, body := fetch(t, base+link); status != http.StatusOK || !strings.Contains(body, "odd file name") {
+      t.Errorf("%s: status = %d", link, status)
+    }
+  }
+
+  if status, _ := fetch(t, base+"/?q=nosuchword"); status != http.StatusNotFound {
+    t.Errorf("no results: status = %d, want 404", status)
+  }
+  if status, body := fetch(t, base+"/"); status != http.StatusOK || !strings.Contains(body, `name="q"`) {
+    t.Errorf("empty query: status = %d, search form shown: %v", status, strings.Contains(body, `name="q"`))
+  }
+
+  // The server runs on loopback, which the ranges now leave out.
+  useConfig(t, func(c *Config) {
+    c.Directory = cfg.Directory
+    c.IPRanges = []IPRange{{CIDR: "192.0.2.0/24"}}
+  })
+  if status, _ := fetch(t, base+"/?q=printer"); status != http.StatusForbidden {
+    t.Errorf("forbidden IP: status = %d, want 403", status)
+  }
+}
+
+var benchWords = []string{"printer", "network", "vacation", "policy", "server", "backup", "office", "access", "report", "budget"}
+
+// benchDocs writes n pages of random words into a random directory tree,
+// the same for every run, and returns the directory and total size.
+func benchDocs(b *testing.B, n int) (string, int64) {
+  b.Helper()
+  rng := rand.New(rand.NewSource(int64(n)))
+  files := map[string]string{}
+  var size int64
+  for i := 0; i < n; i++ {
+    dir := ""
+    for depth := rng.Intn(4); depth > 0; depth-- {
+      dir += fmt.Sprintf("d%d/", rng.Intn(5))
+    }
+    var sb strings.Builder
+    sb.WriteString("<html><body>")
+    for j := 0; j < 50+rng.Intn(200); j++ {
+      sb.WriteString("<p>" + benchWords[rng.Intn(len(benchWords))] + " text</p>")
+    }
+    sb.WriteString("</body></html>")
+    files[fmt.Sprintf("%spage%d.html", dir, i)] = sb.String()
+    size += int64(sb.Len())
+  }
+  return writeDocs(b, files), size
+}
+
+func BenchmarkSearchFiles(b *testing.B) {
+  for _, n := range []int{10, 100, 1000} {
+    b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+      dir, size := benchDocs(b, n)
+      docs := docsFS(dir)
+      b.ReportAllocs()
+      b.SetBytes(size)
+      b.ResetTimer()
+      for i := 0; i < b.N; i++ {
+        files, err := searchFiles(context.Background(), docs, searchPatterns)
+        if err != nil || len(files) != n {
+          b.Fatalf("searchFiles found %d files: %v", len(files), err)
+        }
+      }
+    })
+  }
+}
+
+// BenchmarkSearchDocuments reads and matches every file, with the text
+// cache off.
+func BenchmarkSearchDocuments(b *testing.B) {
+  for _, n := range []int{10, 100, 1000} {
+    b.Run(fmt.Sprintf("files=%d", n), func(b *testing.B) {
+      dir, size := benchDocs(b, n)
+      useConfig(b, func(c *Config) { c.Directory = dir })
+      docs := docsFS(dir)
+      b.ReportAllocs()
+      b.SetBytes(size)
+      b.ResetTimer()
+      for i := 0; i < b.N; i++ {
+        err := searchDocuments(context.Background(), docs, "backup", searchOptions{}, func(SearchResult) error { return nil })
+        if err != nil {
+          b.Fatal(err)
+        }
+      }
+    })
+  }
+}
+
+func FuzzExtractText(f *testing.F) {
+  for _, seed := range []string{
+    "",
+    "<p>plain</p>",
+    "<script>x()</script><style>p{}</style>text",
+    "<div><div><div><b>deep</b></div></div></div>",
+    "&amp;&lt;&#0;&#xD800;",
+    "<title>\xff\xfe</title>\x80broken",
+    "<table><tr><td>cell<td>cell",
+  } {
+    f.Add(seed)
+  }
+  f.Fuzz(func(t *testing.T, input string) {
+    doc, err := html.Parse(strings.NewReader(input))
+    if err != nil {
+      return
+    }
+    if text := extractText(doc); !utf8.ValidString(text) {
+      t.Errorf("extractText(%q) = %q, not valid UTF-8", input, text)
+    }
+  })
+}
+
+func FuzzHandleSearch(f *testing.F) {
+  serveDocs(f, map[string]string{
+    "it/printers.html": "<title>Printers</title><p>Printer setup on every floor.</p>",
+    "it/vpn.html": "<p>Connecting to the vpn from home.</p>",
+    "hr/vacation.html": "<p>Vacation policy & <b>leave</b>.</p>",
+    "odd/a#b %.html": "<p>odd file name</p>",
+  }, nil)
+  for _, seed := range []string{"printer", "", " ", "a", "vpn home", "<script>", "%zz", "\xff", strings.Repeat("x", 300), "файл"} {
+    f.Add(seed)
+  }
+  f.Fuzz(func(t *testing.T, q string) {
+    target := "/?" + url.Values{"q": {q}}.Encode()
+    switch w := get(handleSearch, target); w.Code {
+    case http.StatusOK, http.StatusBadRequest, http.StatusNotFound:
+    default:
+      t.Errorf("%s: status = %d", target, w.Code)
+    }
+  })
+}
+
+func FuzzIsIPInRange(f *testing.F) {
+  for _, seed := range [][2]string{
+    {"192.168.1.20", "192.168.1.0/24"},
+    {"2001:db8::1", "2001:db8::/32"},
+    {"::ffff:10.1.2.3", "10.0.0.0/8"},
+    {"127.0.0.1", "127.0.0.0/8"},
+    {"", ""},
+    {"not an ip", "not a cidr"},
+    {"10.0.0.1", "10.0.0.0/33"},
+    {"fe80::1%eth0", "fe80::/10"},
+    {"1.2.3.4", "1.2.3.4/-1"},
+  } {
+    f.Add(seed[0], seed[1])
+  }
+  f.Fuzz(func(t *testing.T, ip, cidr string) {
+    isIPInRange(ip, []string{cidr})
+    in := isIPInRange(ip, []string{cidr, cidr + "/8", "0.0.0.0/0"})
+    if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil && !in {
+      t.Errorf("IPv4 address %q is not in 0.0.0.0/0", ip)
+    }
+  })
+}
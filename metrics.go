@@ -0,0 +1,37 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+)
+
+// cacheHitRate is the share of lookups that were hits, 0 before any.
+func cacheHitRate(hits, misses int64) float64 {
+  if hits+misses == 0 {
+    return 0
+  }
+  return float64(hits) / float64(hits+misses)
+}
+
+// handleMetrics reports the cache counters in the Prometheus text format,
+// for scraping. The same numbers are in /admin/stats as JSON.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+  caches := []struct {
+    name string
+    hits, misses int64
+    entries int
+  }{
+    {"query_cache", queryCacheHits.Load(), queryCacheMisses.Load(), queries.Load().Len()},
+    {"text_cache", textCacheHits.Load(), textCacheMisses.Load(), texts.Load().Len()},
+  }
+  for _, c := range caches {
+    fmt.Fprintf(w, "# TYPE wika_%s_hits_total counter\nwika_%s_hits_total %d\n", c.name, c.name, c.hits)
+    fmt.Fprintf(w, "# TYPE wika_%s_misses_total counter\nwika_%s_misses_total %d\n", c.name, c.name, c.misses)
+    fmt.Fprintf(w, "# TYPE wika_%s_hit_rate gauge\nwika_%s_hit_rate %g\n", c.name, c.name, cacheHitRate(c.hits, c.misses))
+    fmt.Fprintf(w, "# TYPE wika_%s_entries gauge\nwika_%s_entries %d\n", c.name, c.name, c.entries)
+  }
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+)
+
+func TestMetrics(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "a.html": "<p>alpha beta</p>",
+    "b.html": "<p>beta gamma delta</p>",
+  }, nil)
+  useCaches(t)
+  hits, misses := queryCacheHits.Load(), queryCacheMisses.Load()
+  queryCacheHits.Store(0)
+  queryCacheMisses.Store(0)
+  t.Cleanup(func() {
+    queryCacheHits.Store(hits)
+    queryCacheMisses.Store(misses)
+  })
+
+  get(handleSearch, "/?q=beta")
+  get(handleSearch, "/?q=beta")
+  get(handleSearch, "/?q=gamma")
+  get(handleSearch, "/?q=gamma")
+  w := get(handleMetrics, "/metrics")
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+    t.Errorf("Content-Type = %q", ct)
+  }
+  for _, want := range []string{
+    "# TYPE wika_query_cache_hit_rate gauge\n",
+    "wika_query_cache_hits_total 2\n",
+    "wika_query_cache_misses_total 2\n",
+    "wika_query_cache_hit_rate 0.5\n",
+    "wika_query_cache_entries 2\n",
+    "wika_text_cache_hit_rate ",
+  } {
+    if !strings.Contains(w.Body.String(), want) {
+      t.Errorf("no %q in\n%s", want, w.Body)
+    }
+  }
+
+  useConfig(t, func(c *Config) { c.IPRanges = []IPRange{{CIDR: "10.0.0.0/8"}} })
+  if w := get(handleMetrics, "/metrics"); w.Code != http.StatusForbidden {
+    t.Errorf("from outside the IP ranges: status = %d, want 403", w.Code)
+  }
+}
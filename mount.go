@@ -0,0 +1,152 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "strings"
+  "time"
+)
+
+// Mount serves another document directory under Prefix, such as
+// "/archive/": its files at Prefix and a JSON search over them at
+// Prefix+"search". IPRanges, when set, replace the global ones for the
+// mount. Mounts are registered at startup.
+type Mount struct {
+  Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+  Directory string `json:"directory" yaml:"directory" toml:"directory"`
+  IPRanges []IPRange `json:"ipRanges,omitempty" yaml:"ipRanges,omitempty" toml:"ipRanges,omitempty"`
+}
+
+// reservedPrefixes are subtrees the main mux already serves.
+var reservedPrefixes = []string{"/", "/static/", "/browse/"}
+
+func validateMounts(mounts []Mount) error {
+  seen := map[string]bool{}
+  for _, m := range mounts {
+    if len(m.Prefix) < 2 || !strings.HasPrefix(m.Prefix, "/") || !strings.HasSuffix(m.Prefix, "/") {
+      return fmt.Errorf("mount prefix must start and end with /, got: %q", m.Prefix)
+    }
+    for _, reserved := range reservedPrefixes {
+      if m.Prefix == reserved {
+        return fmt.Errorf("mount prefix %s is already in use", m.Prefix)
+      }
+    }
+    if seen[m.Prefix] {
+      return fmt.Errorf("mount prefix %s is repeated", m.Prefix)
+    }
+    seen[m.Prefix] = true
+    if m.Directory == "" {
+      return fmt.Errorf("mount %s needs a directory", m.Prefix)
+    }
+  }
+  return nil
+}
+
+// mountDocs is a mount's documents. Search code tells it apart from the
+// main directory: its cached text is keyed under the prefix, its results
+// link below the prefix, and the index, which only covers the main
+// directory, is not consulted for it.
+type mountDocs struct {
+  fs.FS
+  prefix string
+}
+
+// textKey is what the text cache knows file in fsys by.
+func textKey(fsys fs.FS, file string) string {
+  if m, ok := fsys.(mountDocs); ok {
+    return m.prefix + file
+  }
+  return file
+}
+
+// docLink is where the document at p in fsys is served, escaped for use
+// as a link.
+func docLink(fsys fs.FS, p string) string {
+  if m, ok := fsys.(mountDocs); ok {
+    return m.prefix + escapeSegments(p)
+  }
+  return staticLinkEscaped(p)
+}
+
+// indexedDocs is the index metadata for documents in fsys.
+func indexedDocs(fsys fs.FS) map[string]*Document {
+  if _, ok := fsys.(mountDocs); ok {
+    return emptyIndex.Docs
+  }
+  return currentIndex().Docs
+}
+
+// checkMountAccess is checkAccess with the mount's own IP ranges, if it
+// has any.
+func checkMountAccess(w http.ResponseWriter, r *http.Request, m Mount) bool {
+  if len(m.IPRanges) == 0 {
+    return checkAccess(w, r)
+  }
+  return checkAccessRanges(w, r, m.IPRanges)
+}
+
+// mountHandler serves m's files and searches.
+func mountHandler(m Mount) http.Handler {
+  docs := mountDocs{FS: docsFS(m.Directory), prefix: m.Prefix}
+  static := http.StripPrefix(m.Prefix, StaticCacheMiddleware(staticMaxAge)(staticHandler(docs)))
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if !checkMountAccess(w, r, m) {
+      return
+    }
+    if r.URL.Path == m.Prefix+"search" {
+      handleMountSearch(w, r, docs)
+      return
+    }
+    static.ServeHTTP(w, r)
+  })
+}
+
+// handleMountSearch returns the documents of a mount matching ?q= as JSON,
+// in the shape /api/search uses, with the same parameters.
+func handleMountSearch(w http.ResponseWriter, r *http.Request, docs mountDocs) {
+  query, err, status := validateSearchParams(r)
+  if err != nil {
+    writeJSONError(w, status, err.Error())
+    return
+  }
+  // Tags come from the index, which only covers the main directory.
+  if r.URL.Query().Get("tag") != "" {
+    writeJSONError(w, http.StatusBadRequest, "tags are not available on mounts")
+    return
+  }
+  cfg := currentConfig()
+  ctx, cancel := searchContext(r)
+  defer cancel()
+  start := time.Now()
+  opts := searchOptionsFor(r)
+  opts.Stats = &scanStats{}
+  resp := searchResponse{Query: query, Results: []SearchResult{}}
+  err = searchDocuments(ctx, docs, query, opts, func(result SearchResult) error {
+    resp.Results = append(resp.Results, result)
+    if cfg.MaxResults > 0 && len(resp.Results) >= cfg.MaxResults {
+      resp.Truncated = true
+      resp.Reason = "max_results"
+      return errStopSearch
+    }
+    return nil
+  })
+  if err != nil {
+    if r.Context().Err() != nil {
+      return
+    }
+    if ctx.Err() != context.DeadlineExceeded {
+      id := logSearchError(w, err)
+      writeJSONError(w, http.StatusInternalServerError, "error searching files (request id "+id+")")
+      return
+    }
+    resp.Truncated = true
+    resp.Reason = "timeout"
+  }
+  sortByFields(resp.Results, opts.Sort)
+  resp.Total = len(resp.Results)
+  resp.Errors = opts.Stats.FileErrors
+  resp.ElapsedMS = time.Since(start).Milliseconds()
+  writeJSON(w, http.StatusOK, resp)
+}
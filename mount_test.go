@@ -0,0 +1,66 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestMounts(t *testing.T) {
+  docs := writeDocs(t, map[string]string{"main.html": "<p>main guide</p>"})
+  open := writeDocs(t, map[string]string{"guide.html": "<title>Guide</title><p>docs guide</p>"})
+  closed := writeDocs(t, map[string]string{"old.html": "<p>archived guide</p>"})
+  useConfig(t, func(c *Config) {
+    c.Directory = docs
+    c.Mounts = []Mount{
+      {Prefix: "/docs/", Directory: open},
+      // The test client, on loopback, is outside the archive's own ranges.
+      {Prefix: "/archive/", Directory: closed, IPRanges: []IPRange{{CIDR: "10.0.0.0/8"}}},
+    }
+  })
+  useIndex(t)
+  base := startServer(t)
+
+  for path, want := range map[string]int{
+    "/static/main.html": http.StatusOK,
+    "/docs/guide.html": http.StatusOK,
+    "/docs/main.html": http.StatusNotFound,
+    "/docs/old.html": http.StatusNotFound,
+    "/static/guide.html": http.StatusNotFound,
+    "/archive/old.html": http.StatusForbidden,
+    "/archive/search?q=guide": http.StatusForbidden,
+  } {
+    if status, _ := fetch(t, base+path); status != want {
+      t.Errorf("%s: status = %d, want %d", path, status, want)
+    }
+  }
+
+  status, body := fetch(t, base+"/docs/search?q=guide")
+  var resp searchResponse
+  if err := json.Unmarshal([]byte(body), &resp); status != http.StatusOK || err != nil {
+    t.Fatalf("/docs/search: status %d, %v: %s", status, err, body)
+  }
+  if len(resp.Results) != 1 || resp.Results[0].URL != "/docs/guide.html" {
+    t.Errorf("/docs/search results = %+v", resp.Results)
+  }
+
+  // The main search covers the main directory only.
+  _, body = fetch(t, base+"/api/search?q=guide")
+  if !strings.Contains(body, `"main.html"`) || strings.Contains(body, "guide.html") || strings.Contains(body, "old.html") {
+    t.Errorf("/api/search: %s", body)
+  }
+
+  // The archive's ranges replace the global ones rather than adding to them.
+  archive := mountHandler(currentConfig().Mounts[1])
+  for remote, want := range map[string]int{"10.1.2.3:1234": http.StatusOK, testClient + ":1234": http.StatusForbidden} {
+    r := httptest.NewRequest(http.MethodGet, "/archive/old.html", nil)
+    r.RemoteAddr = remote
+    w := httptest.NewRecorder()
+    archive.ServeHTTP(w, r)
+    if w.Code != want {
+      t.Errorf("/archive/old.html from %s: status = %d, want %d", remote, w.Code, want)
+    }
+  }
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+  "encoding/xml"
+  "fmt"
+  "net/http"
+)
+
+// openSearchShortNameMax is the longest ShortName the OpenSearch 1.1 spec
+// allows, in characters.
+const openSearchShortNameMax = 16
+
+type openSearchURL struct {
+  Type string `xml:"type,attr"`
+  Rel string `xml:"rel,attr,omitempty"`
+  Template string `xml:"template,attr"`
+}
+
+type openSearchDescription struct {
+  XMLName xml.Name `xml:"OpenSearchDescription"`
+  Xmlns string `xml:"xmlns,attr"`
+  ShortName string `xml:"ShortName"`
+  Description string `xml:"Description"`
+  InputEncoding string `xml:"InputEncoding"`
+  Image string `xml:"Image,omitempty"`
+  URLs []openSearchURL `xml:"Url"`
+}
+
+// handleOpenSearch serves the OpenSearch description that lets browsers
+// add the wiki search to their search bar. The names default to the site
+// title. The self link lets browsers check the description for updates.
+func handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  cfg := currentConfig()
+  lang := requestLanguage(r)
+  shortName := cfg.OpenSearchShortName
+  if shortName == "" {
+    shortName = truncateRunes(siteTitle(lang), openSearchShortNameMax)
+  }
+  description := cfg.OpenSearchDescription
+  if description == "" {
+    description = siteTitle(lang)
+  }
+  desc := openSearchDescription{
+    Xmlns: "http://a9.com/-/spec/opensearch/1.1/",
+    ShortName: shortName,
+    Description: description,
+    InputEncoding: "UTF-8",
+    Image: absoluteURL(r, "/favicon.ico"),
+    URLs: []openSearchURL{
+      {Type: "text/html", Template: absoluteURL(r, "/") + "?q={searchTerms}"},
+      {Type: "application/opensearchdescription+xml", Rel: "self", Template: absoluteURL(r, "/opensearch.xml")},
+    },
+  }
+
+  w.Header().Set("Content-Type", "application/opensearchdescription+xml; charset=utf-8")
+  w.Write([]byte(xml.Header))
+  enc := xml.NewEncoder(w)
+  enc.Indent("", "  ")
+  if err := enc.Encode(desc); err != nil {
+    fmt.Println("Error generating opensearch.xml: ", err)
+  }
+}
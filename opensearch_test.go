@@ -0,0 +1,48 @@
+package main
+
+import (
+  "encoding/xml"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestOpenSearchDescription(t *testing.T) {
+  useConfig(t, func(c *Config) {
+    c.SiteTitle = "A very long company wiki title"
+  })
+  r := httptest.NewRequest(http.MethodGet, "/opensearch.xml", nil)
+  r.Host = "wiki.example:8080"
+  w := httptest.NewRecorder()
+  handleOpenSearch(w, r)
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/opensearchdescription+xml") {
+    t.Errorf("Content-Type = %q", ct)
+  }
+  var desc openSearchDescription
+  if err := xml.Unmarshal(w.Body.Bytes(), &desc); err != nil {
+    t.Fatal(err)
+  }
+  if desc.XMLName.Space != "http://a9.com/-/spec/opensearch/1.1/" {
+    t.Errorf("namespace = %q", desc.XMLName.Space)
+  }
+  if desc.ShortName != "A very long comp" || desc.Description != "A very long company wiki title" {
+    t.Errorf("ShortName = %q, Description = %q", desc.ShortName, desc.Description)
+  }
+  if desc.InputEncoding != "UTF-8" {
+    t.Errorf("InputEncoding = %q", desc.InputEncoding)
+  }
+  templates := map[string]string{}
+  for _, u := range desc.URLs {
+    templates[u.Type] = u.Template
+  }
+  if got, want := templates["text/html"], "http://wiki.example:8080/?q={searchTerms}"; got != want {
+    t.Errorf("search template = %q, want %q", got, want)
+  }
+  if got, want := templates["application/opensearchdescription+xml"], "http://wiki.example:8080/opensearch.xml"; got != want {
+    t.Errorf("self template = %q, want %q", got, want)
+  }
+}
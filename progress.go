@@ -0,0 +1,147 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sync"
+  "sync/atomic"
+)
+
+// IndexProgress is how far the running index build has got. Percent is
+// rounded down, so it only reaches 100 with the last file.
+type IndexProgress struct {
+  Indexed int `json:"indexed"`
+  Total int `json:"total"`
+  Percent int `json:"percent"`
+}
+
+// progressBuffer is how many updates a slow subscriber may fall behind
+// before further updates to it are dropped. The final update is never
+// dropped, and closing the channel then marks the end of the build.
+const progressBuffer = 16
+
+var (
+  // progressSubscribers holds one channel per /admin/progress stream.
+  progressSubscribers sync.Map // chan IndexProgress -> struct{}
+  // lastProgress is the latest update of the running build, or nil when
+  // no build is running.
+  lastProgress atomic.Pointer[IndexProgress]
+)
+
+func subscribeProgress() chan IndexProgress {
+  ch := make(chan IndexProgress, progressBuffer)
+  progressSubscribers.Store(ch, struct{}{})
+  return ch
+}
+
+// unsubscribeProgress stops updates to ch. Only finishProgress closes
+// channels, so a send can't race with a close.
+func unsubscribeProgress(ch chan IndexProgress) {
+  progressSubscribers.Delete(ch)
+}
+
+func startProgress() {
+  lastProgress.Store(&IndexProgress{})
+}
+
+// publishProgress sends an update to every subscriber without blocking
+// the build.
+func publishProgress(indexed, total int) {
+  p := IndexProgress{Indexed: indexed, Total: total}
+  if total > 0 {
+    p.Percent = indexed * 100 / total
+  }
+  lastProgress.Store(&p)
+  progressSubscribers.Range(func(key, _ interface{}) bool {
+    select {
+    case key.(chan IndexProgress) <- p:
+    default:
+    }
+    return true
+  })
+}
+
+// finishProgress ends the build. Every subscriber gets the final update,
+// making room in a full buffer by dropping its oldest update, and then has
+// its channel closed, which its stream reports as the done event. Builds
+// are serialized by reindexMu, so this is the only sender.
+func finishProgress() {
+  final := *lastProgress.Swap(nil)
+  progressSubscribers.Range(func(key, _ interface{}) bool {
+    if _, ok := progressSubscribers.LoadAndDelete(key); !ok {
+      return true
+    }
+    ch := key.(chan IndexProgress)
+    select {
+    case ch <- final:
+    default:
+      select {
+      case <-ch:
+      default:
+      }
+      ch <- final
+    }
+    close(ch)
+    return true
+  })
+}
+
+// handleReindexProgress streams the progress of the running index build,
+// or of the next one when none is running, as server-sent events. The
+// stream ends with a done event once the build finishes.
+func handleReindexProgress(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  if !isFeatureEnabled(featureSSEProgress) {
+    http.Error(w, "Feature not available", http.StatusNotFound)
+    return
+  }
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+    return
+  }
+  ch := subscribeProgress()
+  defer unsubscribeProgress(ch)
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-store")
+  w.Header().Set("X-Accel-Buffering", "no")
+  w.WriteHeader(http.StatusOK)
+  if p := lastProgress.Load(); p != nil {
+    writeEvent(w, "progress", *p)
+  }
+  flusher.Flush()
+
+  var last IndexProgress
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case p, ok := <-ch:
+      if !ok {
+        writeEvent(w, "done", last)
+        flusher.Flush()
+        return
+      }
+      // The final update repeats the last one unless updates were dropped.
+      if p != last {
+        last = p
+        writeEvent(w, "progress", p)
+        flusher.Flush()
+      }
+    }
+  }
+}
+
+// writeEvent writes one server-sent event with v as its JSON data.
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+  data, err := json.Marshal(v)
+  if err != nil {
+    fmt.Println("Error encoding event: ", err)
+    return
+  }
+  fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
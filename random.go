@@ -0,0 +1,31 @@
+package main
+
+import (
+  "math/rand"
+  "net/http"
+  "sort"
+  "strings"
+)
+
+// handleRandom redirects to a random indexed document, optionally one
+// under ?prefix=. Paths are sorted, so the documents under a prefix are a
+// contiguous range and no walk is needed.
+func handleRandom(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  paths := currentIndex().Paths
+  if prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "/"); prefix != "" {
+    start := sort.SearchStrings(paths, prefix)
+    end := start + sort.Search(len(paths)-start, func(i int) bool {
+      return !strings.HasPrefix(paths[start+i], prefix)
+    })
+    paths = paths[start:end]
+  }
+  if len(paths) == 0 {
+    renderError(w, r, http.StatusNotFound, "error.no_pages_title", "error.no_pages")
+    return
+  }
+  w.Header().Set("Cache-Control", "no-store")
+  http.Redirect(w, r, staticLinkEscaped(paths[rand.Intn(len(paths))]), http.StatusFound)
+}
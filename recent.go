@@ -0,0 +1,77 @@
+package main
+
+import (
+  "net/http"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+type RecentQuery struct {
+  Query string `json:"query"`
+  Time time.Time `json:"time"`
+  Results int `json:"results"`
+  IP string `json:"ip"`
+}
+
+// recentQueries is a fixed-size ring buffer of the latest searches.
+type recentQueries struct {
+  mu sync.Mutex
+  buf []RecentQuery
+  next int
+  full bool
+}
+
+// recent is nil when recent queries are disabled, and swapped when
+// /admin/config changes them.
+var recent atomic.Pointer[recentQueries]
+
+func newRecentQueries(size int) *recentQueries {
+  if size <= 0 {
+    return nil
+  }
+  return &recentQueries{buf: make([]RecentQuery, size)}
+}
+
+func (q *recentQueries) Add(e RecentQuery) {
+  if q == nil {
+    return
+  }
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  q.buf[q.next] = e
+  q.next = (q.next + 1) % len(q.buf)
+  if q.next == 0 {
+    q.full = true
+  }
+}
+
+// List returns the buffered queries, newest first.
+func (q *recentQueries) List() []RecentQuery {
+  list := []RecentQuery{}
+  if q == nil {
+    return list
+  }
+  q.mu.Lock()
+  defer q.mu.Unlock()
+  n := q.next
+  if q.full {
+    n = len(q.buf)
+  }
+  for i := 1; i <= n; i++ {
+    list = append(list, q.buf[(q.next-i+len(q.buf))%len(q.buf)])
+  }
+  return list
+}
+
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  q := recent.Load()
+  if q == nil {
+    writeJSONError(w, http.StatusNotFound, "recent queries are disabled")
+    return
+  }
+  writeJSON(w, http.StatusOK, q.List())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "strconv"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+type ReindexReport struct {
+  Start time.Time `json:"start"`
+  End time.Time `json:"end"`
+  Documents int `json:"documents"`
+  Added int `json:"added"`
+  Changed int `json:"changed"`
+  Removed int `json:"removed"`
+  Errors []string `json:"errors"`
+}
+
+const webhookTimeout = 5 * time.Second
+const webhookRetryDelay = 2 * time.Second
+
+var reindexMu sync.Mutex
+
+// indexReady is set once the first index build has finished, successfully
+// or not. Until then searches are refused and /healthz reports not ready.
+var indexReady atomic.Bool
+
+// indexBuilding reports whether the first index build is still running,
+// and how far it has got in percent.
+func indexBuilding() (bool, int) {
+  if indexReady.Load() {
+    return false, 100
+  }
+  if p := lastProgress.Load(); p != nil {
+    return true, p.Percent
+  }
+  return true, 0
+}
+
+// refuseWhileIndexing answers 503 with a Retry-After while the first index
+// build runs, as an HTML page or, for API requests, as JSON. It reports
+// whether it did.
+func refuseWhileIndexing(w http.ResponseWriter, r *http.Request, api bool) bool {
+  building, percent := indexBuilding()
+  if !building {
+    return false
+  }
+  w.Header().Set("Retry-After", strconv.Itoa(indexingRetryAfter))
+  if api {
+    writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "still indexing", "percent": percent})
+    return true
+  }
+  renderError(w, r, http.StatusServiceUnavailable, "error.indexing_title", "error.indexing", percent)
+  return true
+}
+
+// indexingRetryAfter is the Retry-After, in seconds, sent while indexing.
+const indexingRetryAfter = 5
+
+// handleHealthz is for load balancers and orchestrators, so it skips the IP
+// check. It is 200 once the first index is built and 503 until then.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Cache-Control", "no-store")
+  if building, percent := indexBuilding(); building {
+    http.Error(w, fmt.Sprintf("indexing %d%%", percent), http.StatusServiceUnavailable)
+    return
+  }
+  fmt.Fprintln(w, "ok")
+}
+
+// rebuildIndex builds a fresh index, swaps it in and reports how it differs
+// from the previous one. On a build error the old index stays in place.
+func rebuildIndex() ReindexReport {
+  reindexMu.Lock()
+  defer reindexMu.Unlock()
+  defer indexReady.Store(true)
+
+  report := ReindexReport{Start: time.Now(), Errors: []string{}}
+  old := currentIndex()
+  cfg := currentConfig()
+  startProgress()
+  defer finishProgress()
+  idx, err := buildIndex(docsFS(cfg.Directory), cfg.MaxFileSize, publishProgress)
+  if err != nil {
+    fmt.Println("Error building index: ", err)
+    report.Errors = append(report.Errors, err.Error())
+    report.Documents = len(old.Docs)
+    report.End = time.Now()
+    return report
+  }
+  setIndex(idx)
+  queries.Load().Purge()
+
+  for p, doc := range idx.Docs {
+    prev, ok := old.Docs[p]
+    if !ok {
+      report.Added++
+    } else if !prev.Modified.Equal(doc.Modified) || prev.Size != doc.Size {
+      report.Changed++
+    }
+  }
+  for p := range old.Docs {
+    if _, ok := idx.Docs[p]; !ok {
+      report.Removed++
+    }
+  }
+  report.Documents = len(idx.Docs)
+  report.Errors = append(report.Errors, idx.Errors...)
+  report.End = time.Now()
+  return report
+}
+
+// reindexPeriodically rebuilds the index every reindexIntervalSeconds,
+// checking the running config each second so changes made through
+// /admin/config apply without a restart. 0 turns it off.
+func reindexPeriodically() {
+  last := time.Now()
+  for range time.Tick(time.Second) {
+    interval := time.Duration(currentConfig().ReindexIntervalSeconds) * time.Second
+    if interval <= 0 || time.Since(last) < interval {
+      continue
+    }
+    notifyWebhook(rebuildIndex())
+    last = time.Now()
+  }
+}
+
+func handleReindex(w http.ResponseWriter, r *http.Request) {
+  if !checkAdmin(w, r) {
+    return
+  }
+  if r.Method != http.MethodPost {
+    w.Header().Set("Allow", http.MethodPost)
+    http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  report := rebuildIndex()
+  go notifyWebhook(report)
+  writeJSON(w, http.StatusOK, report)
+}
+
+// notifyWebhook posts the report to the configured WebhookURL, retrying once.
+// Delivery problems are only logged.
+func notifyWebhook(report ReindexReport) {
+  cfg := currentConfig()
+  if cfg.WebhookURL == "" {
+    return
+  }
+  body, err := json.Marshal(report)
+  if err != nil {
+    fmt.Println("Error encoding webhook payload: ", err)
+    return
+  }
+  client := &http.Client{Timeout: webhookTimeout}
+  for attempt := 1; attempt <= 2; attempt++ {
+    if attempt > 1 {
+      time.Sleep(webhookRetryDelay)
+    }
+    req, err := http.NewRequest(http.MethodPost, cfg.WebhookURL, bytes.NewReader(body))
+    if err != nil {
+      fmt.Println("Error creating webhook request: ", err)
+      return
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if cfg.WebhookSecret != "" {
+      mac := hmac.New(sha256.New, []byte(cfg.WebhookSecret))
+      mac.Write(body)
+      req.Header.Set("X-Wika-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+      fmt.Println("Webhook delivery failed (attempt", attempt, "):", err)
+      continue
+    }
+    resp.Body.Close()
+    fmt.Println("Webhook delivered with status", resp.Status)
+    if resp.StatusCode < 300 {
+      return
+    }
+  }
+}
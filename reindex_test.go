@@ -0,0 +1,49 @@
+package main
+
+import (
+  "net/http"
+  "testing"
+)
+
+func TestReadinessDuringFirstBuild(t *testing.T) {
+  useConfig(t, func(c *Config) {
+    c.Directory = writeDocs(t, map[string]string{"a.html": "<p>printer setup</p>"})
+  })
+  old, ready := index.Load(), indexReady.Load()
+  index.Store(nil)
+  indexReady.Store(false)
+  t.Cleanup(func() {
+    index.Store(old)
+    indexReady.Store(ready)
+  })
+
+  // Holding reindexMu stalls the build, as a slow disk would.
+  reindexMu.Lock()
+  done := make(chan struct{})
+  go func() {
+    rebuildIndex()
+    close(done)
+  }()
+  endpoints := map[string]http.HandlerFunc{
+    "/healthz": handleHealthz,
+    "/?q=printer": handleSearch,
+    "/api/search?q=printer": handleAPISearch,
+  }
+  for target, handler := range endpoints {
+    w := get(handler, target)
+    if w.Code != http.StatusServiceUnavailable {
+      t.Errorf("%s while building: status = %d, want 503", target, w.Code)
+    }
+    if target != "/healthz" && w.Header().Get("Retry-After") == "" {
+      t.Errorf("%s while building: no Retry-After", target)
+    }
+  }
+  reindexMu.Unlock()
+  <-done
+
+  for target, handler := range endpoints {
+    if w := get(handler, target); w.Code != http.StatusOK {
+      t.Errorf("%s after the build: status = %d, want 200", target, w.Code)
+    }
+  }
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+  "fmt"
+  "math"
+  "net/http"
+  "path"
+  "sort"
+  "strings"
+  "unicode"
+)
+
+const maxRelated = 10
+
+// relatedMinWords is the length below which a document's similarity is
+// scaled down, so that a stub sharing one rare word with the page does not
+// outrank real siblings.
+const relatedMinWords = 50
+
+// termCounts counts the lowercased words of at least two letters or digits.
+func termCounts(words []string) map[string]int {
+  counts := map[string]int{}
+  for _, word := range words {
+    for _, term := range strings.FieldsFunc(strings.ToLower(word), func(c rune) bool {
+      return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+    }) {
+      if len([]rune(term)) >= 2 {
+        counts[term]++
+      }
+    }
+  }
+  return counts
+}
+
+// termVectors replaces each document's term counts with a unit-length
+// tf-idf vector, given how many documents each term occurs in. Terms found
+// in every document weigh nothing.
+func termVectors(idx *Index, df map[string]int) {
+  n := float64(len(idx.Docs))
+  for _, doc := range idx.Docs {
+    vector := make(map[string]float64, len(doc.terms))
+    var norm float64
+    for term, count := range doc.terms {
+      w := (1 + math.Log(float64(count))) * math.Log(n/float64(df[term]))
+      vector[term] = w
+      norm += w * w
+    }
+    if norm > 0 {
+      norm = math.Sqrt(norm)
+      for term := range vector {
+        vector[term] /= norm
+      }
+    }
+    doc.vector = vector
+    doc.terms = nil
+  }
+}
+
+func cosine(a, b map[string]float64) float64 {
+  if len(a) > len(b) {
+    a, b = b, a
+  }
+  var dot float64
+  for term, w := range a {
+    dot += w * b[term]
+  }
+  return dot
+}
+
+type RelatedDoc struct {
+  Path string `json:"path"`
+  URL string `json:"url"`
+  Title string `json:"title,omitempty"`
+  Score float64 `json:"score"`
+}
+
+// relatedDocs returns up to maxRelated documents most similar to doc,
+// excluding doc itself and pages that share no terms with it.
+func relatedDocs(idx *Index, doc *Document) []RelatedDoc {
+  related := []RelatedDoc{}
+  for _, other := range idx.Docs {
+    if other == doc || isHiddenPath(other.Path) {
+      continue
+    }
+    score := cosine(doc.vector, other.vector)
+    if other.WordCount < relatedMinWords {
+      score *= float64(other.WordCount) / relatedMinWords
+    }
+    if score <= 0 {
+      continue
+    }
+    related = append(related, RelatedDoc{Path: other.Path, URL: staticLinkEscaped(other.Path), Title: other.Title, Score: score})
+  }
+  sort.Slice(related, func(i, j int) bool {
+    if related[i].Score != related[j].Score {
+      return related[i].Score > related[j].Score
+    }
+    return related[i].Path < related[j].Path
+  })
+  if len(related) > maxRelated {
+    related = related[:maxRelated]
+  }
+  return related
+}
+
+// relatedFor looks up ?path= in the index.
+func relatedFor(r *http.Request) (*Document, []RelatedDoc, bool) {
+  idx := currentIndex()
+  doc, ok := idx.Docs[strings.TrimPrefix(path.Clean("/"+r.URL.Query().Get("path")), "/")]
+  if !ok {
+    return nil, nil, false
+  }
+  return doc, relatedDocs(idx, doc), true
+}
+
+func handleAPIRelated(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  doc, related, ok := relatedFor(r)
+  if !ok {
+    writeJSONError(w, http.StatusNotFound, "page not found")
+    return
+  }
+  writeJSON(w, http.StatusOK, map[string]interface{}{
+    "path": doc.Path,
+    "related": related,
+  })
+}
+
+// handleRelated lists the related pages as a flat results page.
+func handleRelated(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  lang := requestLanguage(r)
+  doc, related, ok := relatedFor(r)
+  if !ok {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_page")
+    return
+  }
+  var results []SearchResult
+  for _, rel := range related {
+    other := currentIndex().Docs[rel.Path]
+    results = append(results, SearchResult{Path: rel.Path, URL: rel.URL, Title: rel.Title, Modified: other.Modified, Size: other.Size})
+  }
+  title := doc.Title
+  if title == "" {
+    title = doc.Path
+  }
+  cfg := currentConfig()
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  err := resultTemplate(r.URL.Query().Get("tmpl")).Execute(w, resultsPage{
+    Lang: lang,
+    SiteTitle: cfg.SiteTitle,
+    LogoURL: logoURL(cfg),
+    Title: translate(lang, "related.title", title),
+    Results: results,
+    Count: len(results),
+    View: viewFlat,
+  })
+  if err != nil {
+    fmt.Println("Error rendering related pages: ", err)
+  }
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+  "unicode"
+  "unicode/utf8"
+)
+
+type SearchResult struct {
+  Path string `json:"path"`
+  URL string `json:"url"`
+  Title string `json:"title,omitempty"`
+  Modified time.Time `json:"modified"`
+  Size int64 `json:"size"`
+  Matches []Match `json:"matches,omitempty"`
+  TOC []TOCEntry `json:"toc,omitempty"`
+  MatchCount int `json:"match_count,omitempty"`
+  Score int `json:"-"`
+}
+
+// newestMtime is the latest modification time among results.
+func newestMtime(results []SearchResult) time.Time {
+  var newest time.Time
+  for _, result := range results {
+    if result.Modified.After(newest) {
+      newest = result.Modified
+    }
+  }
+  return newest
+}
+
+func (r SearchResult) leaf(query string) leafInfo {
+  return leafInfo{Title: r.Title, Link: resultLink(r.Path, query), Modified: r.Modified, Size: r.Size, TOC: r.TOC}
+}
+
+// Match is one occurrence of the query in a document. Offset counts runes
+// into the document text as returned by /api/text.
+// Line counts lines of that text from 1, and Context holds the lines
+// around it.
+type Match struct {
+  Offset int `json:"offset"`
+  Line int `json:"line"`
+  Snippet string `json:"snippet"`
+  Context []ContextLine `json:"context,omitempty"`
+}
+
+// ContextLine is one line of a match's context. Match marks the lines the
+// occurrence itself is on.
+type ContextLine struct {
+  Number int `json:"number"`
+  Text string `json:"text"`
+  Match bool `json:"match,omitempty"`
+}
+
+// errStopSearch can be returned by an emit callback to end the search early
+// without reporting an error.
+var errStopSearch = errors.New("stop search")
+
+var (
+  errEmptyQuery = errors.New("query is empty")
+  errQueryTooLong = errors.New("query is too long")
+  errQueryTooShort = errors.New("query term is too short")
+  errBadScope = errors.New("in must be author or keywords")
+  errBadSince = errors.New("since must be a date (2006-01-02) or an RFC 3339 time")
+  errBadLines = fmt.Errorf("lines must be a number from 0 to %d", maxContextLines)
+  errFeatureUnavailable = errors.New("feature not available")
+  errBadDir = errors.New("path must be a top-level directory")
+  errBadExt = errors.New("ext must be one of the searchable file extensions")
+  errBadSort = fmt.Errorf("sort must be up to %d of score, date and path, separated by commas", maxSortFields)
+  errBadEncoding = errors.New("query string is malformed")
+)
+
+// checkQueryEncoding reports a query string r.URL.Query() cannot fully
+// parse, such as one with an invalid %-escape. Query() drops such
+// parameters silently, so a mangled ?q= would otherwise look absent.
+func checkQueryEncoding(r *http.Request) error {
+  if _, err := url.ParseQuery(r.URL.RawQuery); err != nil {
+    return errBadEncoding
+  }
+  return nil
+}
+
+// queryTooLong reports whether query has more than maxQueryLength
+// characters.
+func queryTooLong(query string) bool {
+  return utf8.RuneCountInString(query) > currentConfig().MaxQueryLength
+}
+
+func queryTooShort(query string) bool {
+  for _, term := range strings.Fields(query) {
+    if utf8.RuneCountInString(term) < currentConfig().MinQueryLength {
+      return true
+    }
+  }
+  return false
+}
+
+// validateQuery trims q and checks it, returning the HTTP status to use
+// when it is invalid.
+func validateQuery(q string) (string, error, int) {
+  query := strings.TrimSpace(q)
+  switch {
+  case query == "":
+    return "", errEmptyQuery, http.StatusBadRequest
+  case queryTooLong(query):
+    return "", errQueryTooLong, http.StatusBadRequest
+  case queryTooShort(query):
+    return "", errQueryTooShort, http.StatusBadRequest
+  }
+  return query, nil, http.StatusOK
+}
+
+func validateSearchParams(r *http.Request) (query string, err error, statusCode int) {
+  if err, status := validateSearchOptions(r); err != nil {
+    return "", err, status
+  }
+  // A tag alone is a search for every page carrying it.
+  if r.URL.Query().Get("tag") != "" && strings.TrimSpace(r.URL.Query().Get("q")) == "" {
+    return "", nil, http.StatusOK
+  }
+  return validateQuery(r.URL.Query().Get("q"))
+}
+
+// validateSearchOptions checks the parameters of r other than the query,
+// for searches that take their queries from elsewhere.
+func validateSearchOptions(r *http.Request) (error, int) {
+  if err := checkQueryEncoding(r); err != nil {
+    return err, http.StatusBadRequest
+  }
+  switch r.URL.Query().Get("in") {
+  case "", scopeAuthor, scopeKeywords:
+  default:
+    return errBadScope, http.StatusBadRequest
+  }
+  if _, err := parseSince(r.URL.Query().Get("since")); err != nil {
+    return errBadSince, http.StatusBadRequest
+  }
+  if _, err := parseContextLines(r.URL.Query().Get("lines")); err != nil {
+    return errBadLines, http.StatusBadRequest
+  }
+  if r.URL.Query().Get("fuzzy") == "1" && !isFeatureEnabled(featureFuzzySearch) {
+    return errFeatureUnavailable, http.StatusBadRequest
+  }
+  if _, err := parseSort(r.URL.Query().Get("sort")); err != nil {
+    return errBadSort, http.StatusBadRequest
+  }
+  if dir, ext := searchFilters(r); !validDir(dir) {
+    return errBadDir, http.StatusBadRequest
+  } else if !validExt(ext) {
+    return errBadExt, http.StatusBadRequest
+  }
+  if queryTooLong(r.URL.Query().Get("tag")) {
+    return errQueryTooLong, http.StatusBadRequest
+  }
+  return nil, http.StatusOK
+}
+
+// queryErrorMessage is the text shown to users for a validateQuery error.
+func queryErrorMessage(lang string, err error) string {
+  switch err {
+  case errEmptyQuery:
+    return translate(lang, "query.empty")
+  case errQueryTooLong:
+    return translate(lang, "query.too_long", currentConfig().MaxQueryLength)
+  case errQueryTooShort:
+    return translate(lang, "query.too_short", currentConfig().MinQueryLength)
+  case errBadScope:
+    return translate(lang, "query.bad_scope")
+  case errBadSince:
+    return translate(lang, "query.bad_since")
+  case errBadLines:
+    return translate(lang, "query.bad_lines", maxContextLines)
+  case errFeatureUnavailable:
+    return translate(lang, "query.feature_unavailable")
+  case errBadDir:
+    return translate(lang, "query.bad_dir")
+  case errBadExt:
+    return translate(lang, "query.bad_ext", strings.Join(searchExtensions(), ", "))
+  case errBadSort:
+    return translate(lang, "query.bad_sort", maxSortFields)
+  case errBadEncoding:
+    return translate(lang, "query.bad_encoding")
+  }
+  return err.Error()
+}
+
+// errSkipFile marks files that are deliberately not searched, such as
+// binaries and files over maxFileSize, as opposed to files that could not
+// be read.
+var errSkipFile = errors.New("not a searchable document")
+
+// fileError is returned by a scan under the "fail" onFileError policy.
+type fileError struct {
+  file string
+  err error
+}
+
+func (e *fileError) Error() string {
+  return e.file + ": " + e.err.Error()
+}
+
+// scanStats collects what a scan skipped. A nil *scanStats is ignored.
+type scanStats struct {
+  FileErrors int
+}
+
+func (s *scanStats) fileError() {
+  if s != nil {
+    s.FileErrors++
+  }
+}
+
+// scanFiles reads and extracts each of files in fsys once, calling fn with
+// the document's result and its text. With the default "skip" onFileError
+// policy an unreadable file is logged, counted in stats and left out; with
+// "fail" it ends the scan.
+func scanFiles(ctx context.Context, fsys fs.FS, files []string, stats *scanStats, fn func(result SearchResult, text string) error) error {
+  docs := indexedDocs(fsys)
+  failFast := currentConfig().OnFileError == onFileErrorFail
+  for _, file := range files {
+    // Checked before and after each file, so a client that goes away
+    // stops the scan without any further reads.
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+    text, info, err := fileText(ctx, fsys, file)
+    if err := ctx.Err(); err != nil {
+      return err
+    }
+    if err == errSkipFile {
+      continue
+    }
+    if err != nil {
+      fmt.Println("Error reading file", file, ":", err)
+      if failFast {
+        return &fileError{file: file, err: err}
+      }
+      stats.fileError()
+      continue
+    }
+
+    p := logicalPath(file)
+    result := SearchResult{Path: p, URL: docLink(fsys, p), Modified: info.ModTime(), Size: info.Size()}
+    if indexed, ok := docs[p]; ok {
+      result.Title = indexed.Title
+    }
+    if err := fn(result, text); err == errStopSearch {
+      return nil
+    } else if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// fileText returns the extracted text of file and its FileInfo, from the
+// text cache when the file is unchanged. Files the index skips as too
+// large are skipped here too.
+func fileText(ctx context.Context, fsys fs.FS, file string) (string, fs.FileInfo, error) {
+  info, err := fs.Stat(fsys, file)
+  if err != nil {
+    return "", nil, err
+  }
+  if limit := currentConfig().MaxFileSize; limit > 0 && info.Size() > limit {
+    return "", nil, errSkipFile
+  }
+  key := textKey(fsys, file)
+  if text, ok := texts.Load().Get(key, info); ok {
+    return text, info, nil
+  }
+
+  content, err := readDocument(ctx, fsys, file)
+  if err == errTooLarge {
+    return "", nil, errSkipFile
+  }
+  if err != nil {
+    return "", nil, err
+  }
+  if isBinary(content) {
+    fmt.Println("Skipping binary file: ", file)
+    return "", nil, errSkipFile
+  }
+  text, err := documentBody(ctx, file, content)
+  if err != nil {
+    return "", nil, err
+  }
+  texts.Load().Add(key, info, text)
+  return text, info, nil
+}
+
+// searchOptions are the per-request settings shared by all search paths.
+// MaxMatches > 0 lists up to that many occurrences per result; Loose
+// treats runs of punctuation and whitespace as a single space; Stats, when
+// set, receives the scan's error counts; Score counts occurrences of the
+// query into each result's Score; Since leaves out documents not modified
+// after it; Lines is how many lines of context each listed match gets on
+// either side; Fuzzy also accepts words a typo or two away from the query's;
+// Dir and Ext keep to one top-level directory and one file extension; Tag
+// keeps to documents carrying a meta keyword and allows an empty query; Sort
+// is the order asked for with ?sort=, applied once all results are in.
+type searchOptions struct {
+  MaxMatches int
+  Lines int
+  Fuzzy bool
+  Loose bool
+  Stats *scanStats
+  Score bool
+  In string
+  Since time.Time
+  Dir string
+  Ext string
+  Tag string
+  Sort []SortField
+}
+
+// parseSince reads ?since= as a date or a full RFC 3339 time. Empty is the
+// zero time, which filters nothing.
+func parseSince(s string) (time.Time, error) {
+  if s == "" {
+    return time.Time{}, nil
+  }
+  if t, err := time.Parse(time.RFC3339, s); err == nil {
+    return t, nil
+  }
+  return time.Parse("2006-01-02", s)
+}
+
+const (
+  defaultContextLines = 2
+  maxContextLines = 20
+)
+
+// parseContextLines reads ?lines=, defaulting to defaultContextLines.
+func parseContextLines(s string) (int, error) {
+  if s == "" {
+    return defaultContextLines, nil
+  }
+  n, err := strconv.Atoi(s)
+  if err != nil || n < 0 || n > maxContextLines {
+    return 0, errBadLines
+  }
+  return n, nil
+}
+
+// Scopes for ?in=, which match against a document's meta tags instead of
+// its text.
+const (
+  scopeAuthor = "author"
+  scopeKeywords = "keywords"
+)
+
+// searchOptionsFor reads ?match=loose or ?match=exact, defaulting to the
+// looseMatch setting, the ?in= scope, ?since=, ?lines=, ?fuzzy=, the ?path=
+// and ?ext= filters, ?tag= and ?sort=, which validateSearchParams has already
+// checked. Sorting by score turns on Score.
+func searchOptionsFor(r *http.Request) searchOptions {
+  opts := searchOptions{Loose: currentConfig().LooseMatch, In: r.URL.Query().Get("in")}
+  opts.Since, _ = parseSince(r.URL.Query().Get("since"))
+  opts.Lines, _ = parseContextLines(r.URL.Query().Get("lines"))
+  opts.Fuzzy = r.URL.Query().Get("fuzzy") == "1"
+  opts.Dir, opts.Ext = searchFilters(r)
+  opts.Tag = normalizeTag(r.URL.Query().Get("tag"))
+  opts.Sort, _ = parseSort(r.URL.Query().Get("sort"))
+  opts.Score = sortsBy(opts.Sort, sortScore)
+  switch r.URL.Query().Get("match") {
+  case "loose":
+    opts.Loose = true
+  case "exact":
+    opts.Loose = false
+  }
+  return opts
+}
+
+// searchDocuments calls emit for each document whose text or keywords
+// contain query, case-insensitively, in walk order. With opts.In only that
+// meta tag is searched.
+func searchDocuments(ctx context.Context, fsys fs.FS, query string, opts searchOptions, emit func(SearchResult) error) error {
+  files, err := searchFiles(ctx, fsys, searchPatterns)
+  if err != nil {
+    return err
+  }
+  return searchFileList(ctx, fsys, files, query, opts, emit)
+}
+
+// searchFileList is searchDocuments restricted to the given files.
+func searchFileList(ctx context.Context, fsys fs.FS, files []string, query string, opts searchOptions, emit func(SearchResult) error) error {
+  needle := foldText(query, opts.Loose)
+  docs := indexedDocs(fsys)
+  return scanFiles(ctx, fsys, filterFiles(files, opts), opts.Stats, func(result SearchResult, text string) error {
+    if result, ok := matchDocument(result, docs[result.Path], text, foldText(text, opts.Loose), query, needle, opts); ok {
+      return emit(result)
+    }
+    return nil
+  })
+}
+
+// matchDocument reports whether the document behind result matches query,
+// whose folded form is needle, and fills in its score and matches as opts
+// ask. doc is its index entry, if any; folded is text folded as needle is.
+func matchDocument(result SearchResult, doc *Document, text, folded, query, needle string, opts searchOptions) (SearchResult, bool) {
+  if (needle == "" && opts.Tag == "") || (!opts.Since.IsZero() && !result.Modified.After(opts.Since)) {
+    return result, false
+  }
+  if needle == "" {
+    // A ?tag= search with no query: filterFiles has kept the tagged
+    // documents only, and they all match.
+    return result, true
+  }
+  switch opts.In {
+  case scopeAuthor:
+    if doc == nil || !strings.Contains(foldText(doc.Author, opts.Loose), needle) {
+      return result, false
+    }
+  case scopeKeywords:
+    if !keywordsMatch(doc, needle, opts.Loose) {
+      return result, false
+    }
+  default:
+    if !strings.Contains(folded, needle) && !(opts.Fuzzy && fuzzyContains(folded, needle)) && !keywordsMatch(doc, needle, opts.Loose) {
+      return result, false
+    }
+  }
+  if opts.Score {
+    result.Score = strings.Count(folded, needle)
+  }
+  if opts.MaxMatches > 0 {
+    result.Matches, result.MatchCount = findMatches(normalizeWhitespace(text), query, opts)
+    if !currentConfig().ShowMatchCount {
+      result.MatchCount = 0
+    }
+  }
+  return result, true
+}
+
+func keywordsMatch(doc *Document, needle string, loose bool) bool {
+  if doc == nil {
+    return false
+  }
+  for _, keyword := range doc.Keywords {
+    if strings.Contains(foldText(keyword, loose), needle) {
+      return true
+    }
+  }
+  return false
+}
+
+// fuzzyEdits is how many typos a query word of the given length may have:
+// none for short words, where one edit makes a different word.
+func fuzzyEdits(n int) int {
+  switch {
+  case n < 4:
+    return 0
+  case n < 8:
+    return 1
+  }
+  return 2
+}
+
+func isWordRune(r rune) bool {
+  return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// fuzzyContains reports whether every word of query is within fuzzyEdits of
+// some word of text. Both are already folded.
+func fuzzyContains(text, query string) bool {
+  words := map[string]bool{}
+  for _, word := range strings.FieldsFunc(text, func(r rune) bool { return !isWordRune(r) }) {
+    words[word] = true
+  }
+  for _, term := range strings.FieldsFunc(query, func(r rune) bool { return !isWordRune(r) }) {
+    t := []rune(term)
+    found := words[term]
+    for word := range words {
+      if found {
+        break
+      }
+      found = withinEdits(t, []rune(word), fuzzyEdits(len(t)))
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
+
+// withinEdits reports whether the Levenshtein distance between a and b is
+// at most k.
+func withinEdits(a, b []rune, k int) bool {
+  if len(a)-len(b) > k || len(b)-len(a) > k {
+    return false
+  }
+  prev := make([]int, len(b)+1)
+  cur := make([]int, len(b)+1)
+  for j := range prev {
+    prev[j] = j
+  }
+  for i := 1; i <= len(a); i++ {
+    cur[0] = i
+    best := cur[0]
+    for j := 1; j <= len(b); j++ {
+      cost := 1
+      if a[i-1] == b[j-1] {
+        cost = 0
+      }
+      cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+      best = min(best, cur[j])
+    }
+    if best > k {
+      return false
+    }
+    prev, cur = cur, prev
+  }
+  return prev[len(b)] <= k
+}
+
+const matchContext = 40
+
+// findMatches returns up to opts.MaxMatches occurrences of query in text,
+// each with a snippet of surrounding context and opts.Lines lines on either
+// side of the lines it is on, and the number of occurrences in all.
+func findMatches(text, query string, opts searchOptions) ([]Match, int) {
+  ellipsis := currentConfig().SnippetEllipsis
+  runes := []rune(text)
+  lines := strings.Split(text, "\n")
+  lineOf := lineNumbers(runes)
+  folded, pos := foldRunes(runes, opts.Loose)
+  needle, _ := foldRunes([]rune(query), opts.Loose)
+  matches := []Match{}
+  if len(needle) == 0 {
+    return matches, 0
+  }
+  total := 0
+  for i := 0; i+len(needle) <= len(folded); i++ {
+    if !hasRunePrefix(folded[i:], needle) {
+      continue
+    }
+    total++
+    if len(matches) == opts.MaxMatches {
+      i += len(needle) - 1
+      continue
+    }
+    offset := pos[i]
+    start, end := offset-matchContext, pos[i+len(needle)-1]+1+matchContext
+    if start < 0 {
+      start = 0
+    }
+    if end > len(runes) {
+      end = len(runes)
+    }
+    first, last := lineOf[offset], lineOf[pos[i+len(needle)-1]]
+    matches = append(matches, Match{
+      Offset: offset,
+      Line: first,
+      Snippet: snippet(runes, start, end, ellipsis),
+      Context: contextLines(lines, first, last, opts.Lines),
+    })
+    i += len(needle) - 1
+  }
+  return matches, total
+}
+
+// snippet is runes[start:end] with whitespace collapsed, marked with
+// ellipsis at each end where the text goes on. The text is normalized, so
+// anything outside the window is more than whitespace.
+func snippet(runes []rune, start, end int, ellipsis string) string {
+  s := strings.Join(strings.Fields(string(runes[start:end])), " ")
+  if start > 0 {
+    s = ellipsis + s
+  }
+  if end < len(runes) {
+    s += ellipsis
+  }
+  return s
+}
+
+// lineNumbers maps each rune index to the 1-based line it is on.
+func lineNumbers(runes []rune) []int {
+  lineOf := make([]int, len(runes))
+  line := 1
+  for i, r := range runes {
+    lineOf[i] = line
+    if r == '\n' {
+      line++
+    }
+  }
+  return lineOf
+}
+
+// hasRunePrefix reports whether runes starts with prefix.
+func hasRunePrefix(runes, prefix []rune) bool {
+  if len(runes) < len(prefix) {
+    return false
+  }
+  for i, r := range prefix {
+    if runes[i] != r {
+      return false
+    }
+  }
+  return true
+}
+
+// contextLines returns lines first to last, both 1-based, marked as the
+// match, with up to n more lines on either side. Near the start or end of
+// the text the context is shorter.
+func contextLines(lines []string, first, last, n int) []ContextLine {
+  from, to := first-n, last+n
+  if from < 1 {
+    from = 1
+  }
+  if to > len(lines) {
+    to = len(lines)
+  }
+  context := make([]ContextLine, 0, to-from+1)
+  for number := from; number <= to; number++ {
+    context = append(context, ContextLine{Number: number, Text: lines[number-1], Match: number >= first && number <= last})
+  }
+  return context
+}
+
+// foldRunes lowercases rune by rune and, when loose, collapses runs of
+// punctuation and whitespace into one space, trimmed at both ends. pos maps
+// each output rune back to its index in runes.
+func foldRunes(runes []rune, loose bool) ([]rune, []int) {
+  folded := make([]rune, 0, len(runes))
+  pos := make([]int, 0, len(runes))
+  for i, r := range runes {
+    if loose && (unicode.IsSpace(r) || unicode.IsPunct(r)) {
+      if len(folded) > 0 && folded[len(folded)-1] != ' ' {
+        folded = append(folded, ' ')
+        pos = append(pos, i)
+      }
+      continue
+    }
+    folded = append(folded, unicode.ToLower(r))
+    pos = append(pos, i)
+  }
+  if len(folded) > 0 && folded[len(folded)-1] == ' ' {
+    folded, pos = folded[:len(folded)-1], pos[:len(pos)-1]
+  }
+  return folded, pos
+}
+
+func foldText(s string, loose bool) string {
+  folded, _ := foldRunes([]rune(s), loose)
+  return string(folded)
+}
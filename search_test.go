@@ -0,0 +1,475 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "net/url"
+  "reflect"
+  "strconv"
+  "strings"
+  "testing"
+  "testing/fstest"
+  "time"
+)
+
+func TestSearchEscapesQuery(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "a.html": "<p>how to &lt;script&gt;alert(1)&lt;/script&gt; safely</p>",
+  }, nil)
+
+  // Once on a results page, once on the no-results page.
+  for target, status := range map[string]int{
+    "/?q=%3Cscript%3Ealert(1)%3C/script%3E": http.StatusOK,
+    "/?q=%3Cscript%3Ealert(2)%3C/script%3E": http.StatusNotFound,
+  } {
+    w := get(handleSearch, target)
+    if w.Code != status {
+      t.Fatalf("%s: status = %d, want %d", target, w.Code, status)
+    }
+    body := w.Body.String()
+    if strings.Contains(body, "<script>alert(") {
+      t.Errorf("%s: query written unescaped", target)
+    }
+    if !strings.Contains(body, "&lt;script&gt;alert(") {
+      t.Errorf("%s: escaped query missing from the page", target)
+    }
+  }
+}
+
+func TestMinQueryLengthBoundary(t *testing.T) {
+  useConfig(t, func(c *Config) { c.MinQueryLength = 3 })
+  tests := []struct {
+    query string
+    err error
+  }{
+    {"ab", errQueryTooShort},
+    {"abc", nil},
+    {"  abc  ", nil},
+    {"abc de", errQueryTooShort},
+    {"abc def", nil},
+    {"жук", nil},
+    {"жу", errQueryTooShort},
+  }
+  for _, tt := range tests {
+    _, err, status := validateQuery(tt.query)
+    if err != tt.err {
+      t.Errorf("validateQuery(%q) = %v, want %v", tt.query, err, tt.err)
+    }
+    if err != nil && status != http.StatusBadRequest {
+      t.Errorf("validateQuery(%q) status = %d, want 400", tt.query, status)
+    }
+  }
+}
+
+func TestQueryLengthCountsRunes(t *testing.T) {
+  serveDocs(t, map[string]string{"a.html": "<p>中文</p>"}, func(c *Config) {
+    c.MinQueryLength = 2
+    c.MaxQueryLength = 4
+  })
+  tests := []struct {
+    query string
+    err error
+  }{
+    {"中", errQueryTooShort}, // three bytes, one rune
+    {"é", errQueryTooShort},
+    {"😀", errQueryTooShort},
+    {"中文", nil},
+    {"中文中文", nil},
+    {"中文中文中", errQueryTooLong},
+  }
+  for _, tt := range tests {
+    if _, err, _ := validateQuery(tt.query); err != tt.err {
+      t.Errorf("validateQuery(%q) = %v, want %v", tt.query, err, tt.err)
+    }
+  }
+
+  w := get(handleAPISearch, "/api/search?q="+url.QueryEscape("中"))
+  if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), errQueryTooShort.Error()) {
+    t.Errorf("API search for one CJK rune: status %d, body %s", w.Code, w.Body)
+  }
+}
+
+func TestSearchEmptyQuery(t *testing.T) {
+  serveDocs(t, map[string]string{"a.html": "<p>printer setup</p>"}, nil)
+  tests := []struct {
+    target string
+    status int
+  }{
+    {"/", http.StatusOK},
+    {"/?view=flat", http.StatusOK},
+    {"/?q=", http.StatusBadRequest},
+    {"/?q=%20%20", http.StatusBadRequest},
+  }
+  for _, tt := range tests {
+    w := get(handleSearch, tt.target)
+    if w.Code != tt.status {
+      t.Errorf("%s: status = %d, want %d", tt.target, w.Code, tt.status)
+    }
+    if tt.status == http.StatusOK && !strings.Contains(w.Body.String(), `name="q"`) {
+      t.Errorf("%s: no search form", tt.target)
+    }
+  }
+
+  // Without a query, a client outside the ranges is still refused.
+  useConfig(t, func(c *Config) {
+    c.Directory = currentConfig().Directory
+    c.IPRanges = []IPRange{{CIDR: "127.0.0.0/8"}}
+  })
+  if w := get(handleSearch, "/"); w.Code != http.StatusForbidden {
+    t.Errorf("forbidden client: status = %d, want 403", w.Code)
+  }
+}
+
+func TestLooseMatch(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "wifi.html": "<p>Connect to the wi-fi network.</p>",
+    "mail.html": "<p>Read e.mail -- twice, via the web (client)</p>",
+  }, nil)
+  tests := []struct {
+    target string
+    want int
+  }{
+    {"/api/search?q=wi+fi&match=loose", 1},
+    {"/api/search?q=wi-fi&match=loose", 1},
+    {"/api/search?q=wi_fi&match=loose", 1},
+    {"/api/search?q=wi+fi&match=exact", 0},
+    {"/api/search?q=wi+fi", 0},
+    {"/api/search?q=wi-fi", 1},
+    {"/api/search?q=e-mail+twice&match=loose", 1},
+    {"/api/search?q=web+client&match=loose", 1},
+  }
+  for _, tt := range tests {
+    if resp := searchAPI(t, tt.target); resp.Total != tt.want {
+      t.Errorf("%s: %d results, want %d", tt.target, resp.Total, tt.want)
+    }
+  }
+
+  useConfig(t, func(c *Config) {
+    c.Directory = currentConfig().Directory
+    c.LooseMatch = true
+  })
+  if resp := searchAPI(t, "/api/search?q=wi+fi"); resp.Total != 1 {
+    t.Errorf("looseMatch on: %d results, want 1", resp.Total)
+  }
+  if resp := searchAPI(t, "/api/search?q=wi+fi&match=exact"); resp.Total != 0 {
+    t.Errorf("looseMatch on, match=exact: %d results, want 0", resp.Total)
+  }
+}
+
+func TestFoldTextLoose(t *testing.T) {
+  for in, want := range map[string]string{
+    "Wi-Fi": "wi fi",
+    "  e.mail -- (client) ": "e mail client",
+    "a_b/c": "a b c",
+    "": "",
+    "---": "",
+  } {
+    if got := foldText(in, true); got != want {
+      t.Errorf("foldText(%q, true) = %q, want %q", in, got, want)
+    }
+  }
+  if got := foldText("Wi-Fi", false); got != "wi-fi" {
+    t.Errorf("foldText(Wi-Fi, false) = %q", got)
+  }
+}
+
+// openCountFS records the files opened and cancels a context once the
+// given number has been.
+type openCountFS struct {
+  fstest.MapFS
+  cancelAt int
+  cancel context.CancelFunc
+  opened []string
+}
+
+func (c *openCountFS) Open(name string) (fs.File, error) {
+  c.opened = append(c.opened, name)
+  if len(c.opened) == c.cancelAt {
+    c.cancel()
+  }
+  return c.MapFS.Open(name)
+}
+
+func TestSearchCancelledMidSearch(t *testing.T) {
+  useConfig(t, nil)
+  ctx, cancel := context.WithCancel(context.Background())
+  defer cancel()
+  docs := &openCountFS{MapFS: fstest.MapFS{}, cancelAt: 3, cancel: cancel}
+  for i := 0; i < 50; i++ {
+    docs.MapFS[fmt.Sprintf("page%02d.html", i)] = &fstest.MapFile{Data: []byte("<p>common text</p>")}
+  }
+
+  found := 0
+  start := time.Now()
+  err := searchDocuments(ctx, docs, "common", searchOptions{}, func(SearchResult) error {
+    found++
+    return nil
+  })
+  if err != context.Canceled {
+    t.Fatalf("searchDocuments error = %v, want context.Canceled", err)
+  }
+  if elapsed := time.Since(start); elapsed > time.Second {
+    t.Errorf("cancelled search took %v", elapsed)
+  }
+  if len(docs.opened) != 3 {
+    t.Errorf("opened %d files, want none after the cancel: %v", len(docs.opened), docs.opened)
+  }
+  if found > 2 {
+    t.Errorf("%d results after the cancel", found)
+  }
+}
+
+func TestOnFileError(t *testing.T) {
+  files := map[string]string{
+    "a.html": "<p>common text</p>",
+    "broken.html.gz": "not gzip",
+    "c.html": "<p>common words</p>",
+  }
+
+  serveDocs(t, files, func(c *Config) { c.OnFileError = onFileErrorSkip })
+  resp := searchAPI(t, "/api/search?q=common")
+  if resp.Total != 2 || resp.Errors != 1 {
+    t.Errorf("skip: %d results and %d errors, want 2 and 1", resp.Total, resp.Errors)
+  }
+  if w := get(handleSearch, "/?q=common"); w.Code != http.StatusOK {
+    t.Errorf("skip: results page status = %d, want 200", w.Code)
+  }
+
+  serveDocs(t, files, func(c *Config) { c.OnFileError = onFileErrorFail })
+  w := get(handleAPISearch, "/api/search?q=common")
+  if w.Code != http.StatusInternalServerError || w.Header().Get("X-Request-ID") == "" {
+    t.Errorf("fail: status = %d, request id %q", w.Code, w.Header().Get("X-Request-ID"))
+  }
+  if strings.Contains(w.Body.String(), "broken.html.gz") {
+    t.Errorf("fail: file name leaked into the response: %s", w.Body)
+  }
+  if w := get(handleSearch, "/?q=common"); w.Code != http.StatusInternalServerError {
+    t.Errorf("fail: results page status = %d, want 500", w.Code)
+  }
+}
+
+func TestSearchMetaScopes(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "vpn.html": `<meta name="author" content="Anna Petrova"><meta name="keywords" content="network, remote access"><p>Connecting from home.</p>`,
+    "printers.html": `<meta name="author" content="Ivan Sidorov"><meta name="keywords" content="hardware"><p>Printer setup by Anna from IT.</p>`,
+    "plain.html": `<p>No meta tags, but mentions hardware.</p>`,
+  }, nil)
+  tests := []struct {
+    target string
+    want string
+  }{
+    {"/api/search?q=petrova&in=author", "vpn.html"},
+    {"/api/search?q=anna&in=author", "vpn.html"},
+    {"/api/search?q=anna", "printers.html"},
+    {"/api/search?q=remote&in=keywords", "vpn.html"},
+    {"/api/search?q=hardware&in=keywords", "printers.html"},
+    {"/api/search?q=hardware", "plain.html printers.html"},
+    // Keywords are searched along with the text.
+    {"/api/search?q=remote+access", "vpn.html"},
+    {"/api/search?q=home&in=keywords", ""},
+  }
+  for _, tt := range tests {
+    resp := searchAPI(t, tt.target+"&sort=path")
+    var paths []string
+    for _, result := range resp.Results {
+      paths = append(paths, result.Path)
+    }
+    if got := strings.Join(paths, " "); got != tt.want {
+      t.Errorf("%s: found %q, want %q", tt.target, got, tt.want)
+    }
+  }
+  if w := get(handleAPISearch, "/api/search?q=anna&in=title"); w.Code != http.StatusBadRequest {
+    t.Errorf("in=title: status = %d, want 400", w.Code)
+  }
+}
+
+func TestContextLinesPlainText(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "log.md": "alpha match\ntwo\nthree\nmatch four\nfive\nsix match",
+  }, nil)
+  // Each match's context as line numbers, the matching line starred.
+  tests := []struct {
+    lines string
+    want []string
+  }{
+    {"0", []string{"1*", "4*", "6*"}},
+    {"1", []string{"1* 2", "3 4* 5", "5 6*"}},
+    {"", []string{"1* 2 3", "2 3 4* 5 6", "4 5 6*"}},
+    {"2", []string{"1* 2 3", "2 3 4* 5 6", "4 5 6*"}},
+    {"10", []string{"1* 2 3 4 5 6", "1 2 3 4* 5 6", "1 2 3 4 5 6*"}},
+  }
+  for _, tt := range tests {
+    resp := searchAPI(t, "/api/search?q=match&allmatches=1&lines="+tt.lines)
+    if len(resp.Results) != 1 {
+      t.Fatalf("lines=%s: %d results", tt.lines, len(resp.Results))
+    }
+    var got []string
+    for _, m := range resp.Results[0].Matches {
+      var numbers []string
+      for _, line := range m.Context {
+        n := strconv.Itoa(line.Number)
+        if line.Match {
+          n += "*"
+          if !strings.Contains(line.Text, "match") {
+            t.Errorf("lines=%s: line %d %q marked as a match", tt.lines, line.Number, line.Text)
+          }
+        }
+        numbers = append(numbers, n)
+      }
+      got = append(got, strings.Join(numbers, " "))
+    }
+    if !reflect.DeepEqual(got, tt.want) {
+      t.Errorf("lines=%s: context = %q, want %q", tt.lines, got, tt.want)
+    }
+  }
+}
+
+func TestMatchSnippetEllipsis(t *testing.T) {
+  useConfig(t, func(c *Config) { c.SnippetEllipsis = "[...]" })
+  // matchContext runes on either side of the match are kept.
+  a, b := strings.Repeat("a", 50), strings.Repeat("b", 50)
+  kept := matchContext - 1 // the space next to the match is one of them
+  tests := []struct {
+    name string
+    text string
+    want string
+  }{
+    {"no truncation", "a short match here", "a short match here"},
+    {"truncated at start", a + " match", "[...]" + a[:kept] + " match"},
+    {"truncated at end", "match " + b, "match " + b[:kept] + "[...]"},
+    {"truncated at both ends", a + " match " + b, "[...]" + a[:kept] + " match " + b[:kept] + "[...]"},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      matches, total := findMatches(tt.text, "match", searchOptions{MaxMatches: 1})
+      if total != 1 || len(matches) != 1 {
+        t.Fatalf("got %d matches, %d in all", len(matches), total)
+      }
+      if got := matches[0].Snippet; got != tt.want {
+        t.Errorf("snippet = %q, want %q", got, tt.want)
+      }
+    })
+  }
+}
+
+func TestFuzzyFeatureFlag(t *testing.T) {
+  for _, enabled := range []bool{false, true} {
+    serveDocs(t, map[string]string{"a.html": "<p>printer setup</p>"}, func(c *Config) {
+      c.Features = map[string]bool{featureFuzzySearch: enabled}
+    })
+    want := http.StatusBadRequest
+    if enabled {
+      want = http.StatusOK
+    }
+    w := get(handleAPISearch, "/api/search?q=printr&fuzzy=1")
+    if w.Code != want {
+      t.Errorf("fuzzy_search %v: API status = %d, want %d", enabled, w.Code, want)
+    }
+    if !enabled && !strings.Contains(w.Body.String(), `"feature not available"`) {
+      t.Errorf("fuzzy_search off: API body = %s", w.Body)
+    }
+    w = get(handleSearch, "/?q=printr&fuzzy=1")
+    if w.Code != want {
+      t.Errorf("fuzzy_search %v: page status = %d, want %d", enabled, w.Code, want)
+    }
+    if !enabled && !strings.Contains(w.Body.String(), translate(currentConfig().Language, "query.feature_unavailable")) {
+      t.Errorf("fuzzy_search off: page = %s", w.Body)
+    }
+  }
+}
+
+func TestSearchDocumentsMapFS(t *testing.T) {
+  useConfig(t, nil)
+  fsys := fstest.MapFS{
+    "index.html": {Data: []byte("<title>Home</title><p>Welcome, see the VPN guide.</p>")},
+    "it/vpn.html": {Data: []byte("<title>VPN</title><p>Install the vpn client. The VPN needs a token.</p>")},
+    "it/old/vpn.html.gz": {Data: []byte(gzipText(t, "<p>legacy vpn notes</p>"))},
+    "it/notes.txt": {Data: []byte("vpn over hotel wifi\n")},
+    "it/vpn.pdf": {Data: []byte("vpn")},
+    "hr/vacation.html": {Data: []byte("<p>vacation policy</p>")},
+    "it/image.html": {Data: []byte("\x89PNG\r\n\x1a\nvpn")},
+  }
+
+  files, err := searchFiles(context.Background(), fsys, searchPatterns)
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := []string{"hr/vacation.html", "index.html", "it/image.html", "it/notes.txt", "it/old/vpn.html.gz", "it/vpn.html"}
+  if !reflect.DeepEqual(files, want) {
+    t.Errorf("searchFiles = %q, want %q", files, want)
+  }
+
+  got := map[string]int{}
+  err = searchDocuments(context.Background(), fsys, "vpn", searchOptions{MaxMatches: 5, Stats: &scanStats{}}, func(result SearchResult) error {
+    got[result.Path] = len(result.Matches)
+    return nil
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  wantMatches := map[string]int{"index.html": 1, "it/vpn.html": 2, "it/old/vpn.html": 1, "it/notes.txt": 1}
+  if !reflect.DeepEqual(got, wantMatches) {
+    t.Errorf("matches per document = %v, want %v", got, wantMatches)
+  }
+
+  idx, err := buildIndex(fsys, 0, func(indexed, total int) {})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(idx.Docs) != 5 || idx.Docs["it/vpn.html"].Title != "VPN" {
+    t.Errorf("indexed %d documents: %v", len(idx.Docs), idx.Paths)
+  }
+}
+
+func TestScoreRanksByOccurrences(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "a-once.html": "<p>The printer is on the second floor.</p>",
+    "b-many.html": "<p>" + strings.Repeat("Printer setup. ", 10) + "</p>",
+  }, nil)
+
+  scores := map[string]int{}
+  err := searchDocuments(context.Background(), docsFS(currentConfig().Directory), "printer", searchOptions{Score: true}, func(result SearchResult) error {
+    scores[result.Path] = result.Score
+    return nil
+  })
+  if err != nil {
+    t.Fatal(err)
+  }
+  if scores["a-once.html"] != 1 || scores["b-many.html"] != 10 {
+    t.Errorf("scores = %v, want 1 and 10", scores)
+  }
+
+  // Walk order puts a-once.html first; sorting by score must not.
+  var order []string
+  for _, result := range searchAPI(t, "/api/search?q=printer&sort=score").Results {
+    order = append(order, result.Path)
+  }
+  if want := []string{"b-many.html", "a-once.html"}; !reflect.DeepEqual(order, want) {
+    t.Errorf("sort=score order = %q, want %q", order, want)
+  }
+  w := get(handleSearch, "/?q=printer&lucky=1")
+  if loc := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.Contains(loc, "b-many.html") {
+    t.Errorf("lucky: status %d, Location %q", w.Code, loc)
+  }
+}
+
+func TestMalformedQueryEncoding(t *testing.T) {
+  serveDocs(t, map[string]string{"a.html": "<p>100% uptime for the vpn</p>"}, nil)
+  message := translate(currentConfig().Language, "query.bad_encoding")
+  for _, target := range []string{"/?q=%zz", "/?q=100%", "/?q=vpn&x=%E0%A4%A", "/?q=%"} {
+    w := get(handleSearch, target)
+    if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), message) {
+      t.Errorf("%s: status %d, want 400 with %q", target, w.Code, message)
+    }
+    api := strings.Replace(target, "/?", "/api/search?", 1)
+    w = get(handleAPISearch, api)
+    if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), errBadEncoding.Error()) {
+      t.Errorf("%s: status %d, body %s", api, w.Code, w.Body)
+    }
+  }
+  if w := get(handleSearch, "/?q=100%25"); w.Code != http.StatusOK {
+    t.Errorf("escaped %%: status %d, want 200", w.Code)
+  }
+}
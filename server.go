@@ -0,0 +1,113 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "net"
+  "net/http"
+  "os"
+  "os/signal"
+  "strconv"
+  "strings"
+  "syscall"
+  "time"
+)
+
+const shutdownTimeout = 10 * time.Second
+const defaultSocketMode = 0660
+
+// listenAddr returns the network and address to listen on. ListenAddr may
+// be "host:port" or "unix:/path/to.sock"; when unset the port is used.
+func listenAddr(c Config) (string, string) {
+  if strings.HasPrefix(c.ListenAddr, "unix:") {
+    return "unix", strings.TrimPrefix(c.ListenAddr, "unix:")
+  }
+  if c.ListenAddr != "" {
+    return "tcp", c.ListenAddr
+  }
+  return "tcp", ":" + c.Port
+}
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// activatedListener returns the socket passed in by systemd socket
+// activation, or nil when the process was not started that way.
+func activatedListener() (net.Listener, error) {
+  return inheritedListener(listenFDsStart)
+}
+
+// inheritedListener is activatedListener for sockets passed starting at
+// file descriptor first.
+func inheritedListener(first int) (net.Listener, error) {
+  if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+    return nil, nil
+  }
+  n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+  if err != nil || n < 1 {
+    return nil, nil
+  }
+  os.Unsetenv("LISTEN_PID")
+  os.Unsetenv("LISTEN_FDS")
+  os.Unsetenv("LISTEN_FDNAMES")
+  if n > 1 {
+    fmt.Println("Socket activation passed", n, "sockets, using the first one")
+  }
+  f := os.NewFile(uintptr(first), "LISTEN_FD_"+strconv.Itoa(first))
+  defer f.Close()
+  return net.FileListener(f)
+}
+
+func listen(c Config) (net.Listener, error) {
+  if ln, err := activatedListener(); ln != nil || err != nil {
+    return ln, err
+  }
+
+  network, addr := listenAddr(c)
+  if network != "unix" {
+    return net.Listen(network, addr)
+  }
+
+  if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+    fmt.Println("Removing stale socket", addr)
+    os.Remove(addr)
+  }
+  ln, err := net.Listen("unix", addr)
+  if err != nil {
+    return nil, err
+  }
+  mode := os.FileMode(defaultSocketMode)
+  if c.SocketMode != "" {
+    m, err := strconv.ParseUint(c.SocketMode, 8, 32)
+    if err != nil {
+      ln.Close()
+      return nil, fmt.Errorf("invalid socket mode %q: %v", c.SocketMode, err)
+    }
+    mode = os.FileMode(m)
+  }
+  if err := os.Chmod(addr, mode); err != nil {
+    ln.Close()
+    return nil, err
+  }
+  return ln, nil
+}
+
+// serve runs srv on ln until SIGINT or SIGTERM, then shuts it down
+// gracefully. Closing a unix listener also removes its socket file.
+func serve(srv *http.Server, ln net.Listener) error {
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+  done := make(chan error, 1)
+  go func() {
+    <-stop
+    fmt.Println("Shutting down")
+    ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+    defer cancel()
+    done <- srv.Shutdown(ctx)
+  }()
+
+  if err := srv.Serve(ln); err != http.ErrServerClosed {
+    return err
+  }
+  return <-done
+}
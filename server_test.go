@@ -0,0 +1,78 @@
+//go:build unix
+
+package main
+
+import (
+  "net"
+  "os"
+  "strconv"
+  "syscall"
+  "testing"
+)
+
+// passListener duplicates ln's socket as systemd would pass it and
+// returns the new file descriptor.
+func passListener(t *testing.T, ln net.Listener) int {
+  t.Helper()
+  f, err := ln.(*net.TCPListener).File()
+  if err != nil {
+    t.Fatal(err)
+  }
+  // inheritedListener takes over the descriptor and closes it, so it gets
+  // a copy of its own.
+  defer f.Close()
+  fd, err := syscall.Dup(int(f.Fd()))
+  if err != nil {
+    t.Fatal(err)
+  }
+  return fd
+}
+
+func TestInheritedListener(t *testing.T) {
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer ln.Close()
+  fd := passListener(t, ln)
+  t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+  t.Setenv("LISTEN_FDS", "1")
+  t.Setenv("LISTEN_FDNAMES", "wika.socket")
+
+  inherited, err := inheritedListener(fd)
+  if err != nil || inherited == nil {
+    t.Fatalf("inheritedListener = %v, %v", inherited, err)
+  }
+  defer inherited.Close()
+  if inherited.Addr().String() != ln.Addr().String() {
+    t.Errorf("listening on %s, want %s", inherited.Addr(), ln.Addr())
+  }
+  for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+    if _, ok := os.LookupEnv(name); ok {
+      t.Errorf("%s is still set", name)
+    }
+  }
+
+  go func() {
+    if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+      conn.Close()
+    }
+  }()
+  conn, err := inherited.Accept()
+  if err != nil {
+    t.Fatal(err)
+  }
+  conn.Close()
+}
+
+func TestInheritedListenerOtherProcess(t *testing.T) {
+  t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+  t.Setenv("LISTEN_FDS", "1")
+  ln, err := inheritedListener(listenFDsStart)
+  if ln != nil || err != nil {
+    t.Errorf("inheritedListener = %v, %v, want nil, nil", ln, err)
+  }
+  if os.Getenv("LISTEN_FDS") != "1" {
+    t.Error("sockets meant for another process were taken")
+  }
+}
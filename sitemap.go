@@ -0,0 +1,120 @@
+package main
+
+import (
+  "encoding/xml"
+  "fmt"
+  "net/http"
+  "net/url"
+  "sort"
+  "strconv"
+)
+
+const sitemapPageSize = 500
+const sitemapXMLPageSize = 50000
+
+func staticLink(p string) string {
+  return "/static/" + p
+}
+
+// staticLinkEscaped is staticLink with each path segment escaped, for use
+// in hrefs and Location headers.
+func staticLinkEscaped(p string) string {
+  return "/static/" + escapeSegments(p)
+}
+
+// sitemapPage returns the sorted documents on the requested ?page= (1-based)
+// along with the total number of pages.
+func sitemapPage(r *http.Request, size int) ([]*Document, int, int) {
+  docs := make([]*Document, 0, len(currentIndex().Docs))
+  for _, doc := range currentIndex().Docs {
+    docs = append(docs, doc)
+  }
+  sort.Slice(docs, func(i, j int) bool { return docs[i].Path < docs[j].Path })
+
+  pages := (len(docs) + size - 1) / size
+  page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+  if page < 1 {
+    page = 1
+  }
+  start := (page - 1) * size
+  if start > len(docs) {
+    start = len(docs)
+  }
+  end := start + size
+  if end > len(docs) {
+    end = len(docs)
+  }
+  return docs[start:end], page, pages
+}
+
+func handleSitemap(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  cfg := currentConfig()
+  docs, page, pages := sitemapPage(r, sitemapPageSize)
+
+  var links []string
+  leaves := map[string]leafInfo{}
+  for _, doc := range docs {
+    link := staticLink(doc.Path)
+    links = append(links, link)
+    leaves[link] = leafInfo{Title: doc.Title, Modified: doc.Modified, Size: doc.Size}
+  }
+  lang := requestLanguage(r)
+  data := resultsPage{
+    Lang: lang,
+    SiteTitle: cfg.SiteTitle,
+    LogoURL: logoURL(cfg),
+    Title: translate(lang, "sitemap.title"),
+    Children: buildTree(links, leaves).Children,
+  }
+  if page > 1 {
+    data.Prev = fmt.Sprintf("?page=%d", page-1)
+  }
+  if page < pages {
+    data.Next = fmt.Sprintf("?page=%d", page+1)
+  }
+
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  err := resultTemplate(r.URL.Query().Get("tmpl")).Execute(w, data)
+  if err != nil {
+    fmt.Println("Error generating sitemap: ", err)
+  }
+}
+
+type sitemapURL struct {
+  Loc string `xml:"loc"`
+  LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+  XMLName xml.Name `xml:"urlset"`
+  Xmlns string `xml:"xmlns,attr"`
+  URLs []sitemapURL `xml:"url"`
+}
+
+func handleSitemapXML(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  docs, _, _ := sitemapPage(r, sitemapXMLPageSize)
+
+  scheme := "http"
+  if r.TLS != nil {
+    scheme = "https"
+  }
+  set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+  for _, doc := range docs {
+    u := url.URL{Scheme: scheme, Host: r.Host, Path: staticLink(doc.Path)}
+    set.URLs = append(set.URLs, sitemapURL{Loc: u.String(), LastMod: doc.Modified.UTC().Format("2006-01-02")})
+  }
+
+  w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+  w.Write([]byte(xml.Header))
+  enc := xml.NewEncoder(w)
+  enc.Indent("", "  ")
+  if err := enc.Encode(set); err != nil {
+    fmt.Println("Error generating sitemap.xml: ", err)
+  }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+  "sort"
+  "strings"
+)
+
+// SortField is a key results can be ordered by with ?sort=.
+type SortField string
+
+const (
+  sortScore SortField = "score"
+  sortDate SortField = "date"
+  sortPath SortField = "path"
+)
+
+// maxSortFields is how many keys ?sort= takes: a primary one and one to
+// break its ties.
+const maxSortFields = 2
+
+// parseSort reads ?sort= as up to maxSortFields comma-separated fields,
+// such as "score,date". Empty means no explicit order.
+func parseSort(s string) ([]SortField, error) {
+  if s == "" {
+    return nil, nil
+  }
+  var fields []SortField
+  for _, name := range strings.Split(s, ",") {
+    field := SortField(strings.TrimSpace(name))
+    switch field {
+    case sortScore, sortDate, sortPath:
+    default:
+      return nil, errBadSort
+    }
+    for _, seen := range fields {
+      if seen == field {
+        return nil, errBadSort
+      }
+    }
+    fields = append(fields, field)
+  }
+  if len(fields) > maxSortFields {
+    return nil, errBadSort
+  }
+  return fields, nil
+}
+
+// sortDescending is the natural direction of field: most occurrences and
+// newest first, paths A to Z.
+func sortDescending(field SortField) bool {
+  return field == sortScore || field == sortDate
+}
+
+// compareResults orders a and b by field, ascending; 0 when they tie or
+// field is empty.
+func compareResults(a, b SearchResult, field SortField) int {
+  switch field {
+  case sortScore:
+    return a.Score - b.Score
+  case sortDate:
+    return a.Modified.Compare(b.Modified)
+  case sortPath:
+    return strings.Compare(a.Path, b.Path)
+  }
+  return 0
+}
+
+// sortResults orders results by primary, breaking ties by secondary, each
+// descending when asked. The sort is stable, so results tied on both keep
+// their order; either field may be empty to leave it out.
+func sortResults(results []SearchResult, primary, secondary SortField, primaryDesc, secondaryDesc bool) {
+  sort.SliceStable(results, func(i, j int) bool {
+    if c := compareResults(results[i], results[j], primary); c != 0 {
+      return (c > 0) == primaryDesc
+    }
+    if c := compareResults(results[i], results[j], secondary); c != 0 {
+      return (c > 0) == secondaryDesc
+    }
+    return false
+  })
+}
+
+// sortByFields is sortResults with fields as parsed from ?sort=, each in
+// its natural direction.
+func sortByFields(results []SearchResult, fields []SortField) {
+  var primary, secondary SortField
+  if len(fields) > 0 {
+    primary = fields[0]
+  }
+  if len(fields) > 1 {
+    secondary = fields[1]
+  }
+  sortResults(results, primary, secondary, sortDescending(primary), sortDescending(secondary))
+}
+
+// sortsBy reports whether fields include field.
+func sortsBy(fields []SortField, field SortField) bool {
+  for _, f := range fields {
+    if f == field {
+      return true
+    }
+  }
+  return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "strings"
+)
+
+// StaticCacheMiddleware lets browsers reuse successful /static/ responses
+// for maxAgeSeconds, which is asked for on each request so config changes
+// apply at once. 304s pass through untouched, and directory URLs are never
+// marked cacheable since they may be file server listings.
+func StaticCacheMiddleware(maxAgeSeconds func() int) func(http.Handler) http.Handler {
+  return func(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      maxAge := maxAgeSeconds()
+      if maxAge <= 0 || r.URL.Path == "" || strings.HasSuffix(r.URL.Path, "/") {
+        next.ServeHTTP(w, r)
+        return
+      }
+      value := fmt.Sprintf("public, max-age=%d", maxAge)
+      next.ServeHTTP(&cacheControlWriter{ResponseWriter: w, value: value}, r)
+    })
+  }
+}
+
+// staticMaxAge is the max-age of /static/ responses in the running config.
+func staticMaxAge() int {
+  return currentConfig().StaticMaxAgeSeconds
+}
+
+type cacheControlWriter struct {
+  http.ResponseWriter
+  value string
+  wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+  if !w.wroteHeader {
+    w.wroteHeader = true
+    if status == http.StatusOK {
+      w.Header().Set("Cache-Control", w.value)
+    }
+  }
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(b []byte) (int, error) {
+  if !w.wroteHeader {
+    w.WriteHeader(http.StatusOK)
+  }
+  return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+  return w.ResponseWriter
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "html/template"
+  "net/http"
+  "time"
+)
+
+// streamFlushEvery is how many results are written between flushes.
+const streamFlushEvery = 10
+
+var streamTemplate *template.Template
+
+type streamItem struct {
+  Lang string
+  Query string
+  Result SearchResult
+}
+
+// streamsResults reports whether the search page can be streamed: the flat
+// list in the default template, with no lucky redirect, feed or ?sort=,
+// which need every result before writing anything.
+func streamsResults(r *http.Request) bool {
+  q := r.URL.Query()
+  return resultsView(r) == viewFlat && q.Get("lucky") != "1" && q.Get("format") == "" && q.Get("tmpl") == "" && q.Get("sort") == ""
+}
+
+// streamSearch writes the flat results list as results are found, flushing
+// after the first result and then every streamFlushEvery results, so a
+// large result set is neither held back nor rendered in one piece. Nothing is written before the first
+// result, so a search without results still gets the 404 page. Results
+// come in walk order and there is no Last-Modified, as neither is known
+// until the end.
+func streamSearch(ctx context.Context, w *responseWriter, r *http.Request, query string, opts searchOptions) {
+  templatesMu.RLock()
+  tmpl := streamTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    w.Error(translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
+    return
+  }
+  cfg := currentConfig()
+  lang := requestLanguage(r)
+  page := resultsPage{
+    Lang: lang,
+    SiteTitle: cfg.SiteTitle,
+    LogoURL: logoURL(cfg),
+    Title: siteTitle(lang),
+    Query: query,
+    Dir: opts.Dir,
+    Ext: opts.Ext,
+    Tag: opts.Tag,
+    Sort: r.URL.Query().Get("sort"),
+    In: opts.In,
+    TaglessURL: withParam(r, "tag", ""),
+    View: viewFlat,
+    TreeURL: withParam(r, "view", viewTree),
+    GroupedURL: withParam(r, "view", viewGrouped),
+    Searches: withSearch(r, query),
+  }
+  toc := showTOC(r)
+  docs := currentIndex().Docs
+
+  cacheKey := queryCacheKey(query, opts)
+  cached, ok := queries.Load().Get(cacheKey)
+  var results []SearchResult
+  emit := func(result SearchResult) error {
+    results = append(results, result)
+    if len(results) == 1 {
+      if err := tmpl.ExecuteTemplate(w, "stream_head", page); err != nil {
+        return err
+      }
+    }
+    if toc {
+      result.TOC = resultTOC(docs[result.Path])
+    }
+    if err := tmpl.ExecuteTemplate(w, "stream_item", streamItem{Lang: lang, Query: query, Result: result}); err != nil {
+      return err
+    }
+    if len(results) == 1 || len(results)%streamFlushEvery == 0 {
+      w.Flush()
+    }
+    return nil
+  }
+
+  var err error
+  if ok {
+    for _, result := range cached {
+      if err = emit(result); err != nil {
+        break
+      }
+    }
+  } else {
+    err = searchDocuments(ctx, docsFS(cfg.Directory), query, opts, emit)
+  }
+  if err != nil {
+    if ctx.Err() == nil {
+      id := logSearchError(w, err)
+      w.Error(translate(requestLanguage(r), "error.searching", id), http.StatusInternalServerError)
+    }
+    return
+  }
+  if !ok {
+    if opts.Stats.FileErrors > 0 {
+      fmt.Println("Skipped", opts.Stats.FileErrors, "unreadable files searching for", query)
+    }
+    queries.Load().Add(cacheKey, results)
+  }
+  recent.Load().Add(RecentQuery{Query: query, Time: time.Now(), Results: len(results), IP: clientIP(r)})
+  usage.Load().AddQuery(query)
+
+  if len(results) == 0 {
+    renderNoResults(w, r, query)
+    return
+  }
+  page.Count = len(results)
+  if err := tmpl.ExecuteTemplate(w, "stream_foot", page); err != nil {
+    fmt.Println("Error generating HTML: ", err)
+  }
+  w.Flush()
+}
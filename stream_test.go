@@ -0,0 +1,100 @@
+package main
+
+import (
+  "io/fs"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync"
+  "testing"
+  "testing/fstest"
+  "time"
+)
+
+// gateFS holds up opening the file called name until gate is closed.
+type gateFS struct {
+  fs fs.FS
+  name string
+  gate chan struct{}
+}
+
+func (g gateFS) Open(name string) (fs.File, error) {
+  if name == g.name {
+    <-g.gate
+  }
+  return g.fs.Open(name)
+}
+
+// flushRecorder is an httptest.ResponseRecorder, safe to read while the
+// handler runs, that closes flushed at the first flush of a body holding
+// marker.
+type flushRecorder struct {
+  mu sync.Mutex
+  w *httptest.ResponseRecorder
+  marker string
+  flushed chan struct{}
+  once sync.Once
+}
+
+func (f *flushRecorder) Header() http.Header {
+  return f.w.Header()
+}
+
+func (f *flushRecorder) Write(b []byte) (int, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  return f.w.Write(b)
+}
+
+func (f *flushRecorder) WriteHeader(status int) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.w.WriteHeader(status)
+}
+
+func (f *flushRecorder) Flush() {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  if strings.Contains(f.w.Body.String(), f.marker) {
+    f.once.Do(func() { close(f.flushed) })
+  }
+}
+
+func TestStreamSendsFirstResultEarly(t *testing.T) {
+  useConfig(t, func(c *Config) { c.Directory = "" })
+  gate := make(chan struct{})
+  old := sampleDocs
+  sampleDocs = gateFS{
+    fs: fstest.MapFS{
+      "a.html": {Data: []byte("<title>First</title><p>printer setup</p>")},
+      "z.html": {Data: []byte("<title>Last</title><p>printer drivers</p>")},
+    },
+    name: "z.html",
+    gate: gate,
+  }
+  t.Cleanup(func() { sampleDocs = old })
+  ready := indexReady.Load()
+  indexReady.Store(true)
+  t.Cleanup(func() { indexReady.Store(ready) })
+
+  w := &flushRecorder{w: httptest.NewRecorder(), marker: "a.html", flushed: make(chan struct{})}
+  done := make(chan struct{})
+  go func() {
+    handleSearch(w, httptest.NewRequest(http.MethodGet, "/?q=printer&view=flat", nil))
+    close(done)
+  }()
+  select {
+  case <-w.flushed:
+  case <-done:
+    t.Fatal("search finished while the last file was held up")
+  case <-time.After(5 * time.Second):
+    close(gate)
+    <-done
+    t.Fatalf("first result not flushed before the last file was read:\n%s", w.w.Body)
+  }
+  close(gate)
+  <-done
+  if body := w.w.Body.String(); !strings.Contains(body, "a.html") || !strings.Contains(body, "z.html") {
+    t.Errorf("body lacks a result:\n%s", body)
+  }
+}
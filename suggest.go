@@ -0,0 +1,84 @@
+package main
+
+import (
+  "net/http"
+  "strings"
+  "unicode/utf8"
+)
+
+// suggestEdits is how far a word of n letters may be from an indexed term
+// to be suggested in its place. Words under three letters are not
+// corrected.
+func suggestEdits(n int) int {
+  switch {
+  case n < 3:
+    return 0
+  case n < 7:
+    return 1
+  }
+  return 2
+}
+
+// nearestTerm returns the indexed term closest to word by edit distance,
+// preferring terms in more documents and then the alphabetically first, or
+// "" when none is close enough.
+func nearestTerm(terms map[string]int, word string) string {
+  w := []rune(word)
+  for k := 1; k <= suggestEdits(len(w)); k++ {
+    best := ""
+    for term, df := range terms {
+      n := utf8.RuneCountInString(term)
+      if n-len(w) > k || len(w)-n > k || !withinEdits(w, []rune(term), k) {
+        continue
+      }
+      if best == "" || df > terms[best] || (df == terms[best] && term < best) {
+        best = term
+      }
+    }
+    if best != "" {
+      return best
+    }
+  }
+  return ""
+}
+
+// suggestQuery spells query with its unknown words replaced by the nearest
+// indexed terms, or returns "" when there is nothing to correct. It walks
+// the whole vocabulary, so it is only meant for searches that found
+// nothing.
+func suggestQuery(query string) string {
+  terms := currentIndex().Terms
+  if len(terms) == 0 {
+    return ""
+  }
+  words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool { return !isWordRune(r) })
+  changed := false
+  for i, word := range words {
+    if terms[word] > 0 {
+      continue
+    }
+    if term := nearestTerm(terms, word); term != "" {
+      words[i] = term
+      changed = true
+    }
+  }
+  if !changed {
+    return ""
+  }
+  return strings.Join(words, " ")
+}
+
+// renderNoResults shows the error page for a search that found nothing,
+// offering to search for the nearest indexed terms instead.
+func renderNoResults(w http.ResponseWriter, r *http.Request, query string) {
+  suggestion := suggestQuery(query)
+  if suggestion == "" {
+    renderError(w, r, http.StatusNotFound, "error.no_results_title", "error.no_results")
+    return
+  }
+  lang := requestLanguage(r)
+  page := newPageData(r, translate(lang, "error.no_results_title"), translate(lang, "error.no_results"))
+  page.Suggestion = suggestion
+  page.SuggestionURL = withParam(r, "q", suggestion)
+  renderErrorPage(w, r, http.StatusNotFound, page)
+}
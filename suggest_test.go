@@ -0,0 +1,58 @@
+package main
+
+import (
+  "html"
+  "net/http"
+  "strings"
+  "testing"
+)
+
+func TestDidYouMean(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "a.html": "<p>printer setup</p>",
+    "b.html": "<p>printer drivers and the vacation policy</p>",
+  }, nil)
+  tests := []struct {
+    query string
+    suggestion string
+  }{
+    {"priner", "printer"},
+    {"vacaton polcy", "vacation policy"},
+    {"printer zzzzzzzz", ""},
+    {"qwertyuiop", ""},
+  }
+  for _, tt := range tests {
+    w := get(handleSearch, "/?q="+strings.ReplaceAll(tt.query, " ", "+"))
+    if w.Code != http.StatusNotFound {
+      t.Errorf("%q: status = %d, want 404", tt.query, w.Code)
+    }
+    body := html.UnescapeString(w.Body.String())
+    if tt.suggestion == "" {
+      if strings.Contains(body, `class="suggestion"`) {
+        t.Errorf("%q: unexpected suggestion in\n%s", tt.query, body)
+      }
+      continue
+    }
+    link := "q=" + strings.ReplaceAll(tt.suggestion, " ", "+")
+    if !strings.Contains(body, "«"+tt.suggestion+"»") || !strings.Contains(body, link) {
+      t.Errorf("%q: no suggestion of %q linking to %s in\n%s", tt.query, tt.suggestion, link, body)
+    }
+  }
+}
+
+func TestNearestTerm(t *testing.T) {
+  terms := map[string]int{"printer": 1, "printed": 3, "print": 2, "ab": 5}
+  tests := []struct {
+    word, want string
+  }{
+    {"printerr", "printer"}, // one edit beats two, even in fewer documents
+    {"printex", "printed"}, // a tie goes to the term in more documents
+    {"ac", ""}, // too short to correct
+    {"scanner", ""},
+  }
+  for _, tt := range tests {
+    if got := nearestTerm(terms, tt.word); got != tt.want {
+      t.Errorf("nearestTerm(%q) = %q, want %q", tt.word, got, tt.want)
+    }
+  }
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "net/http"
+  "net/url"
+  "sort"
+  "strings"
+)
+
+// Tag cloud font sizes, in percent, for the rarest and the most common
+// tags shown.
+const (
+  tagMinSize = 100
+  tagMaxSize = 250
+)
+
+// normalizeTag is the form keywords are grouped and looked up by:
+// trimmed, single-spaced, lowercased, with ё spelled е.
+func normalizeTag(tag string) string {
+  tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+  return strings.ReplaceAll(tag, "ё", "е")
+}
+
+// buildTags maps each normalized keyword of the documents at paths to the
+// paths carrying it, in the order given.
+func buildTags(docs map[string]*Document, paths []string) map[string][]string {
+  tags := map[string][]string{}
+  for _, p := range paths {
+    seen := map[string]bool{}
+    for _, keyword := range docs[p].Keywords {
+      if tag := normalizeTag(keyword); tag != "" && !seen[tag] {
+        seen[tag] = true
+        tags[tag] = append(tags[tag], p)
+      }
+    }
+  }
+  return tags
+}
+
+// taggedWith reports whether the document at p carries tag, already
+// normalized.
+func taggedWith(idx *Index, tag, p string) bool {
+  paths := idx.Tags[tag]
+  i := sort.SearchStrings(paths, p)
+  return i < len(paths) && paths[i] == p
+}
+
+func tagLink(tag string) string {
+  return "/?tag=" + url.QueryEscape(tag)
+}
+
+type tagEntry struct {
+  Tag string
+  Count int
+  URL string
+  Size int
+}
+
+type tagsPage struct {
+  pageData
+  Tags []tagEntry
+}
+
+var tagsTemplate *template.Template
+
+// tagCloud lists the tags carried by at least minCount documents, A to Z,
+// each sized between tagMinSize and tagMaxSize by its document count.
+func tagCloud(minCount int) []tagEntry {
+  var entries []tagEntry
+  least, most := 0, 0
+  for tag, paths := range currentIndex().Tags {
+    if len(paths) < minCount {
+      continue
+    }
+    entries = append(entries, tagEntry{Tag: tag, Count: len(paths), URL: tagLink(tag)})
+    if least == 0 || len(paths) < least {
+      least = len(paths)
+    }
+    most = max(most, len(paths))
+  }
+  for i := range entries {
+    entries[i].Size = tagMinSize
+    if most > least {
+      entries[i].Size += (tagMaxSize - tagMinSize) * (entries[i].Count - least) / (most - least)
+    }
+  }
+  sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+  return entries
+}
+
+// handleTags shows the cloud of meta keywords, each linking to the pages
+// carrying it.
+func handleTags(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  templatesMu.RLock()
+  tmpl := tagsTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    http.Error(w, translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
+    return
+  }
+  lang := requestLanguage(r)
+  data := tagsPage{pageData: newPageData(r, translate(lang, "tags.title"), ""), Tags: tagCloud(currentConfig().TagMinCount)}
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if err := tmpl.Execute(w, data); err != nil {
+    fmt.Println("Error rendering tags: ", err)
+  }
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "net/http"
+  "net/url"
+  "os"
+  "os/signal"
+  "strings"
+  "sync"
+  "syscall"
+  "time"
+  "github.com/Albatrosicks/temp-wika/assets"
+)
+
+const defaultTemplateName = "default"
+
+// resultsPage is the data passed to every results template. Query is the
+// raw user input and must only be rendered through html/template escaping.
+type resultsPage struct {
+  Lang string
+  SiteTitle string
+  LogoURL string
+  Title string
+  Query string
+  Dir string
+  Ext string
+  Tag string
+  Sort string
+  In string
+  TaglessURL string
+  Children []*Node
+  Path string
+  Prev string
+  Next string
+  Results []SearchResult
+  Count int
+  View string
+  TreeURL string
+  FlatURL string
+  Groups []ResultGroup
+  GroupURL string
+  GroupedURL string
+  Sections []ResultSection
+  Searches []string
+}
+
+// The results views. The tree is the default and is "" in pages; viewTree
+// is only used to pick it explicitly over a remembered view.
+const (
+  viewTree = "tree"
+  viewFlat = "flat"
+  viewGrouped = "grouped"
+)
+
+// viewCookie remembers the last view picked with ?view=.
+const viewCookie = "view"
+
+func knownView(view string) bool {
+  return view == viewTree || view == viewFlat || view == viewGrouped
+}
+
+// resultsView is the view asked for with ?view=, or else the remembered
+// one.
+func resultsView(r *http.Request) string {
+  view := r.URL.Query().Get("view")
+  if !knownView(view) {
+    if c, err := r.Cookie(viewCookie); err == nil {
+      view = c.Value
+    }
+  }
+  if view == viewFlat || view == viewGrouped {
+    return view
+  }
+  return ""
+}
+
+// rememberView stores a view picked with ?view= in a cookie, so later
+// searches use it without the parameter. It must be called before
+// anything is written.
+func rememberView(w http.ResponseWriter, r *http.Request) {
+  view := r.URL.Query().Get("view")
+  if !knownView(view) {
+    return
+  }
+  if c, err := r.Cookie(viewCookie); err == nil && c.Value == view {
+    return
+  }
+  http.SetCookie(w, &http.Cookie{
+    Name: viewCookie,
+    Value: view,
+    Path: "/",
+    MaxAge: 365 * 24 * 60 * 60,
+    HttpOnly: true,
+    SameSite: http.SameSiteLaxMode,
+  })
+}
+
+// withParam returns the current request's URL with key set to value, or
+// removed when value is empty, keeping all other parameters.
+func withParam(r *http.Request, key, value string) string {
+  q := r.URL.Query()
+  if value == "" {
+    q.Del(key)
+  } else {
+    q.Set(key, value)
+  }
+  return "?" + q.Encode()
+}
+
+var (
+  templatesMu sync.RWMutex
+  resultTemplates map[string]*template.Template
+)
+
+// formatDate writes t as "12 Jan 2025" in lang.
+func formatDate(lang string, t time.Time) string {
+  return fmt.Sprintf("%d %s %d", t.Day(), translate(lang, fmt.Sprintf("month.%d", t.Month())), t.Year())
+}
+
+func formatSize(lang string, n int64) string {
+  switch {
+  case n >= 1<<20:
+    return translate(lang, "size.mb", float64(n)/(1<<20))
+  case n >= 1<<10:
+    return translate(lang, "size.kb", (n+1<<9)>>10)
+  }
+  return translate(lang, "size.b", n)
+}
+
+// fileMeta is the compact "date, size" shown next to a result, without the
+// size when hideFileSizes is set.
+func fileMeta(lang string, modified time.Time, size int64) string {
+  if modified.IsZero() {
+    return ""
+  }
+  if currentConfig().HideFileSizes {
+    return formatDate(lang, modified)
+  }
+  return formatDate(lang, modified) + ", " + formatSize(lang, size)
+}
+
+func siteTitle(lang string) string {
+  if title := currentConfig().SiteTitle; title != "" {
+    return title
+  }
+  return translate(lang, "site.title")
+}
+
+// templateFuncs are available in every template. t looks up a message in
+// the catalog for the page's .Lang; searchDirs and searchExts list the
+// choices of the search form's filters.
+var templateFuncs = template.FuncMap{
+  "t": translate,
+  "renderNode": renderNode,
+  "fileMeta": fileMeta,
+  "formatDate": formatDate,
+  "resultLink": resultLink,
+  "staticLinkEscaped": staticLinkEscaped,
+  "renderTOC": renderTOC,
+  "searchDirs": topDirs,
+  "searchExts": searchExtensions,
+}
+
+// escapeSegments path-escapes each segment of a node name, keeping the
+// slashes that collapseChains puts between directories.
+func escapeSegments(p string) string {
+  segments := strings.Split(p, "/")
+  for i, segment := range segments {
+    segments[i] = url.PathEscape(segment)
+  }
+  return strings.Join(segments, "/")
+}
+
+// renderNode builds markup by hand, so every file name is escaped here:
+// path segments with url.PathEscape for the href, and HTML-escaped for text.
+// The optional lang picks the language of file dates and sizes.
+func renderNode(node *Node, fullPath string, lang ...string) template.HTML {
+  l := currentConfig().Language
+  if len(lang) > 0 {
+    l = lang[0]
+  }
+  return renderTree(node, fullPath, 0, l)
+}
+
+// maxTOCLevel is the deepest heading shown in a result's table of contents.
+const maxTOCLevel = 3
+
+// showTOC reads ?toc=1 or ?toc=0, defaulting to the showTOC setting.
+func showTOC(r *http.Request) bool {
+  switch r.URL.Query().Get("toc") {
+  case "1":
+    return true
+  case "0":
+    return false
+  }
+  return currentConfig().ShowTOC
+}
+
+// resultTOC is the h1–h3 headings of doc, which may be nil.
+func resultTOC(doc *Document) []TOCEntry {
+  var toc []TOCEntry
+  if doc == nil {
+    return toc
+  }
+  for _, entry := range doc.TOC {
+    if entry.Level <= maxTOCLevel {
+      toc = append(toc, entry)
+    }
+  }
+  return toc
+}
+
+// renderTOC lists headings indented by level, linking to href#id for
+// headings that have an id.
+func renderTOC(href string, toc []TOCEntry) template.HTML {
+  if len(toc) == 0 {
+    return ""
+  }
+  var sb strings.Builder
+  sb.WriteString(`<ul class="toc">`)
+  for _, entry := range toc {
+    text := template.HTMLEscapeString(entry.Text)
+    if entry.ID != "" {
+      text = fmt.Sprintf(`<a href="%s">%s</a>`, template.HTMLEscapeString(href+"#"+url.PathEscape(entry.ID)), text)
+    }
+    fmt.Fprintf(&sb, `<li class="toc-h%d">%s</li>`, entry.Level, text)
+  }
+  sb.WriteString(`</ul>`)
+  return template.HTML(sb.String())
+}
+
+// openDepth is how many directory levels start expanded.
+const openDepth = 2
+
+// renderTree renders directories as <details> sections with their result
+// counts, open only near the top. The unnamed node for the leading slash of
+// links adds no level of its own.
+func renderTree(node *Node, fullPath string, depth int, lang string) template.HTML {
+  if len(fullPath) > 0 {
+    fullPath += "/"
+  }
+  fullPath += escapeSegments(node.Path)
+  name := template.HTMLEscapeString(node.Path)
+  if len(node.Children) == 0 {
+    href := "./" + fullPath
+    if node.Link != "" {
+      href = node.Link
+    }
+    meta := ""
+    if m := fileMeta(lang, node.Modified, node.Size); m != "" {
+      meta = ` <span class="meta">` + template.HTMLEscapeString(m) + `</span>`
+    }
+    meta += string(renderTOC(href, node.TOC))
+    if node.DisplayName != "" {
+      return template.HTML(fmt.Sprintf(`<li><a href="%s" title="%s">%s</a>%s</li>`, template.HTMLEscapeString(href), name, template.HTMLEscapeString(node.DisplayName), meta))
+    }
+    return template.HTML(fmt.Sprintf(`<li><a href="%s">%s</a>%s</li>`, template.HTMLEscapeString(href), name, meta))
+  }
+  if node.Path == "" {
+    var children string
+    for _, child := range node.Children {
+      children += string(renderTree(child, fullPath, depth, lang))
+    }
+    return template.HTML(children)
+  }
+  var children string
+  for _, child := range node.Children {
+    children += string(renderTree(child, fullPath, depth+1, lang))
+  }
+  open := ""
+  if depth < openDepth {
+    open = " open"
+  }
+  return template.HTML(fmt.Sprintf(`<li class="dir"><details%s><summary>%s <span class="count">(%d)</span></summary><ul>%s</ul></details></li>`, open, name, node.Count, children))
+}
+
+func newResultTemplate(name, text string) (*template.Template, error) {
+  tmpl, err := withHeader(template.New(name).Funcs(templateFuncs))
+  if err != nil {
+    return nil, err
+  }
+  return tmpl.Parse(text)
+}
+
+// loadTemplates parses the default results template and every template
+// listed in the config. Templates that fail to load are logged and left
+// out, so requests for them fall back to the default.
+func loadTemplates(paths map[string]string) map[string]*template.Template {
+  templates := map[string]*template.Template{}
+  text, err := readAsset("results.html")
+  if err == nil {
+    templates[defaultTemplateName], err = newResultTemplate(defaultTemplateName, string(text))
+  }
+  if err != nil {
+    fmt.Println("Error loading results template, using the built-in one: ", err)
+    text, _ = assets.FS.ReadFile("results.html")
+    templates[defaultTemplateName] = template.Must(newResultTemplate(defaultTemplateName, string(text)))
+  }
+  for name, path := range paths {
+    text, err := os.ReadFile(path)
+    if err != nil {
+      fmt.Println("Error loading template", name, ":", err)
+      continue
+    }
+    tmpl, err := newResultTemplate(name, string(text))
+    if err != nil {
+      fmt.Println("Error parsing template", name, ":", err)
+      continue
+    }
+    templates[name] = tmpl
+  }
+  return templates
+}
+
+func reloadTemplates() {
+  loadCatalogs()
+  templates := loadTemplates(currentConfig().Templates)
+  templatesMu.Lock()
+  resultTemplates = templates
+  templatesMu.Unlock()
+  loadPageTemplates()
+}
+
+func resultTemplate(name string) *template.Template {
+  templatesMu.RLock()
+  defer templatesMu.RUnlock()
+  if tmpl, ok := resultTemplates[name]; ok {
+    return tmpl
+  }
+  return resultTemplates[defaultTemplateName]
+}
+
+func reloadTemplatesOnSIGHUP() {
+  c := make(chan os.Signal, 1)
+  signal.Notify(c, syscall.SIGHUP)
+  for range c {
+    fmt.Println("SIGHUP received, reloading templates")
+    reloadTemplates()
+  }
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+  "net/http"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestResultTemplateSelection(t *testing.T) {
+  compact := filepath.Join(t.TempDir(), "compact.html")
+  if err := os.WriteFile(compact, []byte(`<p class="compact">{{.Count}} for {{.Query}}</p>`), 0644); err != nil {
+    t.Fatal(err)
+  }
+  serveDocs(t, map[string]string{
+    "a.html": "<title>A</title><p>printer setup</p>",
+  }, func(c *Config) {
+    c.Templates = map[string]string{"compact": compact}
+  })
+  reloadTemplates()
+  t.Cleanup(reloadTemplates)
+
+  def := get(handleSearch, "/?q=printer")
+  custom := get(handleSearch, "/?q=printer&tmpl=compact")
+  unknown := get(handleSearch, "/?q=printer&tmpl=missing")
+  for _, resp := range []*http.Response{def.Result(), custom.Result(), unknown.Result()} {
+    if resp.StatusCode != http.StatusOK {
+      t.Fatalf("status = %d, want 200", resp.StatusCode)
+    }
+  }
+  if got := custom.Body.String(); got != `<p class="compact">1 for printer</p>` {
+    t.Errorf("tmpl=compact rendered %q", got)
+  }
+  if def.Body.String() == custom.Body.String() || strings.Contains(def.Body.String(), `class="compact"`) {
+    t.Error("default and compact templates rendered the same page")
+  }
+  // Links on the page keep ?tmpl=, so the pages are not identical.
+  if strings.Contains(unknown.Body.String(), `class="compact"`) || !strings.Contains(unknown.Body.String(), "a.html") {
+    t.Error("an unknown template did not fall back to the default")
+  }
+}
+
+func TestResultsPagesPrintAndMobile(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "it/network/a-very-long-file-name-that-has-to-wrap-on-a-phone.html": "<title>A</title><p>printer setup</p>",
+    "it/b.html": "<title>B</title><p>printer drivers</p>",
+  }, nil)
+  const viewport = `<meta name="viewport" content="width=device-width, initial-scale=1">`
+  for _, view := range []string{viewTree, viewFlat, viewGrouped} {
+    body := get(handleSearch, "/?q=printer&view="+view).Body.String()
+    head, rest, ok := strings.Cut(body, "</head>")
+    if !ok || !strings.Contains(head, viewport) {
+      t.Errorf("%s view: no viewport meta in the head", view)
+    }
+    // The print script runs last, after every branch is on the page.
+    if !strings.HasSuffix(strings.TrimSpace(rest), "</script>\n</body>\n</html>") || !strings.Contains(rest, `addEventListener("beforeprint"`) {
+      t.Errorf("%s view: page does not end with the print script:\n%s", view, rest)
+    }
+  }
+  for _, target := range []string{"/", "/?q=nothingmatches"} {
+    if body := get(handleSearch, target).Body.String(); !strings.Contains(body, viewport) {
+      t.Errorf("%s: no viewport meta", target)
+    }
+  }
+
+  css := get(handleStyle, "/style.css").Body.String()
+  _, printRules, ok := strings.Cut(css, "@media print {")
+  if !ok {
+    t.Fatal("style.css has no print rules")
+  }
+  for _, want := range []string{".wika-header form", ".wika-history", "display: none", `a[data-href]::after`, `attr(data-href)`} {
+    if !strings.Contains(printRules, want) {
+      t.Errorf("print rules lack %q", want)
+    }
+  }
+  if _, small, ok := strings.Cut(css, "@media (max-width: 600px) {"); !ok || !strings.Contains(small, "min-height: 40px") {
+    t.Error("style.css has no touch-sized controls for small screens")
+  }
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/fs"
+  "net/http"
+  "os"
+  "path"
+  "strings"
+  "golang.org/x/net/html"
+)
+
+var errInvalidPath = errors.New("invalid path")
+
+// resolveDocument maps a wiki-relative path to the file holding it in
+// docs, rejecting anything that escapes its root or is not a searchable
+// type. A page stored compressed is found under its uncompressed name.
+func resolveDocument(docs fs.FS, rel string) (string, fs.FileInfo, error) {
+  if rel == "" || strings.Contains(rel, "\\") || strings.Contains(rel, "\x00") {
+    return "", nil, errInvalidPath
+  }
+  clean := path.Clean("/" + rel)
+  if clean != "/"+strings.TrimPrefix(rel, "/") {
+    return "", nil, errInvalidPath
+  }
+  name := strings.TrimPrefix(clean, "/")
+  if !isSearchable(name) {
+    return "", nil, errInvalidPath
+  }
+
+  file := name
+  info, err := fs.Stat(docs, file)
+  if os.IsNotExist(err) && !strings.HasSuffix(file, ".gz") {
+    file += ".gz"
+    info, err = fs.Stat(docs, file)
+  }
+  if err != nil {
+    return "", nil, err
+  }
+  if info.IsDir() {
+    return "", nil, os.ErrNotExist
+  }
+  return file, info, nil
+}
+
+func isSearchable(name string) bool {
+  base := path.Base(name)
+  for _, pattern := range searchPatterns {
+    if matched, _ := path.Match(pattern, base); matched {
+      return true
+    }
+    if matched, _ := path.Match(pattern, base+".gz"); matched {
+      return true
+    }
+  }
+  return false
+}
+
+// normalizeWhitespace collapses runs of spaces within lines and drops
+// empty lines.
+func normalizeWhitespace(s string) string {
+  var lines []string
+  for _, line := range strings.Split(s, "\n") {
+    if line = strings.Join(strings.Fields(line), " "); line != "" {
+      lines = append(lines, line)
+    }
+  }
+  return strings.Join(lines, "\n")
+}
+
+// bodyText is the text of doc's body, or of all of doc if it has none, as
+// searches match it and /api/text returns it. The title is left out: it
+// is not parsed as HTML, so any markup in it would show up as text.
+func bodyText(doc *html.Node) string {
+  body := findElement(doc, "body")
+  if body == nil {
+    body = doc
+  }
+  return strings.ToValidUTF8(documentText(body), "\uFFFD")
+}
+
+// isPlainTextPath reports whether the document at p is plain text, such as
+// a .txt or Markdown file, which is searched line by line as it is rather
+// than parsed as HTML.
+func isPlainTextPath(p string) bool {
+  switch strings.ToLower(path.Ext(logicalPath(p))) {
+  case ".txt", ".md":
+    return true
+  }
+  return false
+}
+
+// plainText is the text of a plain text document, with Windows line
+// endings turned into "\n" and invalid UTF-8 replaced as bodyText does.
+func plainText(content []byte) string {
+  return strings.ToValidUTF8(strings.ReplaceAll(string(content), "\r\n", "\n"), "\uFFFD")
+}
+
+// documentBody is the text of file that searches look in: the file itself
+// for plain text, the text of the body for HTML.
+func documentBody(ctx context.Context, file string, content []byte) (string, error) {
+  if isPlainTextPath(file) {
+    return plainText(content), nil
+  }
+  doc, err := parseHTML(ctx, content)
+  if err != nil {
+    return "", err
+  }
+  return bodyText(doc), nil
+}
+
+func handleText(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+
+  cfg := currentConfig()
+  docs := docsFS(cfg.Directory)
+  rel := r.URL.Query().Get("path")
+  file, info, err := resolveDocument(docs, rel)
+  if err == errInvalidPath {
+    writeJSONError(w, http.StatusBadRequest, "invalid path")
+    return
+  }
+  if err != nil {
+    writeJSONError(w, http.StatusNotFound, "document not found")
+    return
+  }
+  if cfg.MaxFileSize > 0 && info.Size() > cfg.MaxFileSize {
+    writeJSONError(w, http.StatusRequestEntityTooLarge, "document is too large")
+    return
+  }
+
+  content, err := readDocument(r.Context(), docs, file)
+  if err == errTooLarge {
+    writeJSONError(w, http.StatusRequestEntityTooLarge, "document is too large")
+    return
+  }
+  if err != nil {
+    if r.Context().Err() == nil {
+      fmt.Println("Error reading file", file, ":", err)
+      writeJSONError(w, http.StatusInternalServerError, "error reading document")
+    }
+    return
+  }
+  if isBinary(content) {
+    writeJSONError(w, http.StatusUnsupportedMediaType, "document is not text")
+    return
+  }
+  text, err := documentBody(r.Context(), file, content)
+  if err != nil {
+    if r.Context().Err() == nil {
+      writeJSONError(w, http.StatusInternalServerError, "error parsing document")
+    }
+    return
+  }
+
+  if indexed, ok := currentIndex().Docs[logicalPath(file)]; ok && indexed.Title != "" {
+    w.Header().Set("X-Document-Title", headerValue(indexed.Title))
+  }
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  fmt.Fprintln(w, normalizeWhitespace(text))
+}
+
+// headerValue makes s safe to send as a header value. Non-ASCII titles are
+// percent-encoded as UTF-8.
+func headerValue(s string) string {
+  var sb strings.Builder
+  for _, b := range []byte(s) {
+    if b < 0x20 || b >= 0x7f || b == '%' {
+      fmt.Fprintf(&sb, "%%%02X", b)
+    } else {
+      sb.WriteByte(b)
+    }
+  }
+  return sb.String()
+}
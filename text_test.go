@@ -0,0 +1,59 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "strings"
+  "testing"
+)
+
+func TestTextEndpoint(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "page.html": `<html><head><title>Guide <b>draft</b></title><style>p { color: red }</style></head>
+<body><h1>Setup</h1><p>first</p><p>second line</p><script>track()</script>
+<p>third</p></body></html>`,
+    "notes.txt": "plain <b>notes</b>\r\nsecond line\r\n",
+  }, nil)
+
+  w := get(handleText, "/api/text?path=page.html")
+  if w.Code != http.StatusOK {
+    t.Fatalf("status = %d: %s", w.Code, w.Body)
+  }
+  want := "Setup first second line\nthird\n"
+  if got := w.Body.String(); got != want {
+    t.Errorf("text = %q, want %q", got, want)
+  }
+  if got := w.Header().Get("X-Document-Title"); got != "Guide draft" {
+    t.Errorf("X-Document-Title = %q, want %q", got, "Guide draft")
+  }
+
+  // Match offsets point into the same text.
+  w = get(handleAPISearch, "/api/search?q=third&allmatches=1")
+  var resp searchResponse
+  if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+    t.Fatal(err)
+  }
+  if len(resp.Results) != 1 || len(resp.Results[0].Matches) != 1 {
+    t.Fatalf("results = %+v", resp.Results)
+  }
+  offset := resp.Results[0].Matches[0].Offset
+  if got := string([]rune(want)[offset:]); !strings.HasPrefix(got, "third") {
+    t.Errorf("offset %d points at %q", offset, got)
+  }
+
+  // Plain text is served as it is, not parsed as HTML.
+  w = get(handleText, "/api/text?path=notes.txt")
+  if got := w.Body.String(); w.Code != http.StatusOK || got != "plain <b>notes</b>\nsecond line\n" {
+    t.Errorf("notes.txt: status %d, text %q", w.Code, got)
+  }
+
+  for target, status := range map[string]int{
+    "/api/text?path=missing.html": http.StatusNotFound,
+    "/api/text?path=../page.html": http.StatusBadRequest,
+    "/api/text?path=page.pdf": http.StatusBadRequest,
+  } {
+    if w := get(handleText, target); w.Code != status {
+      t.Errorf("%s: status = %d, want %d", target, w.Code, status)
+    }
+  }
+}
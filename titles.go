@@ -0,0 +1,195 @@
+package main
+
+import (
+  "fmt"
+  "html/template"
+  "net/http"
+  "net/url"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "unicode"
+  "golang.org/x/text/collate"
+  "golang.org/x/text/language"
+)
+
+// titlesPreview is how many titles of each letter the overview shows;
+// titlesPageSize is how many a letter's own page shows at once.
+const titlesPreview = 50
+const titlesPageSize = 500
+
+// otherLetter is the bucket for titles starting with a digit or with a
+// letter outside the Cyrillic and Latin alphabets.
+const otherLetter = "#"
+
+type titleEntry struct {
+  Title string
+  Path string
+  URL string
+}
+
+// titleBucket is every title starting with Letter. On a page, Entries may
+// be a slice of the bucket; Count is always its full size.
+type titleBucket struct {
+  Letter string
+  URL string
+  Count int
+  More bool
+  Entries []titleEntry
+}
+
+type titlesPage struct {
+  pageData
+  Letters []titleBucket
+  Buckets []titleBucket
+  Prev string
+  Next string
+}
+
+var titlesTemplate *template.Template
+
+// titlesCache holds the buckets for the index they were built from, so
+// they are rebuilt only after a reindex.
+var titlesCache struct {
+  sync.Mutex
+  index *Index
+  buckets []titleBucket
+}
+
+// titleLetter is the capitalized first letter or digit of title, or
+// otherLetter. Cyrillic letters sort before Latin ones, and otherLetter
+// last.
+func titleLetter(title string) (string, int) {
+  for _, r := range title {
+    switch {
+    case unicode.Is(unicode.Cyrillic, r):
+      return string(unicode.ToUpper(r)), 0
+    case unicode.Is(unicode.Latin, r):
+      return string(unicode.ToUpper(r)), 1
+    case unicode.IsLetter(r) || unicode.IsDigit(r):
+      return otherLetter, 2
+    }
+  }
+  return otherLetter, 2
+}
+
+func titlesLink(letter string) string {
+  return "/titles?letter=" + url.QueryEscape(letter)
+}
+
+// titleBuckets groups the documents of the current index by the first
+// letter of their title, or of their path when untitled. Documents in dot
+// directories are left out, as in /random.
+func titleBuckets() []titleBucket {
+  idx := currentIndex()
+  titlesCache.Lock()
+  defer titlesCache.Unlock()
+  if titlesCache.index == idx {
+    return titlesCache.buckets
+  }
+
+  c := collate.New(language.Russian, collate.IgnoreCase)
+  byLetter := map[string][]titleEntry{}
+  alphabet := map[string]int{}
+  for _, p := range idx.Paths {
+    title := idx.Docs[p].Title
+    if title == "" {
+      title = p
+    }
+    letter, order := titleLetter(title)
+    byLetter[letter] = append(byLetter[letter], titleEntry{Title: title, Path: p, URL: staticLinkEscaped(p)})
+    alphabet[letter] = order
+  }
+  buckets := []titleBucket{}
+  for letter, entries := range byLetter {
+    sort.Slice(entries, func(i, j int) bool {
+      if cmp := c.CompareString(entries[i].Title, entries[j].Title); cmp != 0 {
+        return cmp < 0
+      }
+      return entries[i].Path < entries[j].Path
+    })
+    buckets = append(buckets, titleBucket{Letter: letter, URL: titlesLink(letter), Count: len(entries), Entries: entries})
+  }
+  sort.Slice(buckets, func(i, j int) bool {
+    a, b := buckets[i].Letter, buckets[j].Letter
+    if alphabet[a] != alphabet[b] {
+      return alphabet[a] < alphabet[b]
+    }
+    return c.CompareString(a, b) < 0
+  })
+  titlesCache.index = idx
+  titlesCache.buckets = buckets
+  return buckets
+}
+
+// handleTitles lists every page by title, А to Я and then A to Z. The
+// overview shows the first titles of each letter; ?letter= shows all of
+// one letter, split into pages of titlesPageSize with ?page=.
+func handleTitles(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  templatesMu.RLock()
+  tmpl := titlesTemplate
+  templatesMu.RUnlock()
+  if tmpl == nil {
+    http.Error(w, translate(requestLanguage(r), "error.generating_html"), http.StatusInternalServerError)
+    return
+  }
+
+  buckets := titleBuckets()
+  lang := requestLanguage(r)
+  data := titlesPage{pageData: newPageData(r, translate(lang, "titles.title"), "")}
+  for _, bucket := range buckets {
+    data.Letters = append(data.Letters, titleBucket{Letter: bucket.Letter, URL: bucket.URL, Count: bucket.Count})
+  }
+
+  letter := strings.ToUpper(r.URL.Query().Get("letter"))
+  if letter == "" {
+    for _, bucket := range buckets {
+      if len(bucket.Entries) > titlesPreview {
+        bucket.Entries = bucket.Entries[:titlesPreview]
+        bucket.More = true
+      }
+      data.Buckets = append(data.Buckets, bucket)
+    }
+  } else {
+    var bucket titleBucket
+    for _, b := range buckets {
+      if b.Letter == letter {
+        bucket = b
+      }
+    }
+    if bucket.Letter == "" {
+      renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_page")
+      return
+    }
+    pages := (len(bucket.Entries) + titlesPageSize - 1) / titlesPageSize
+    page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+    if page < 1 {
+      page = 1
+    }
+    if page > pages {
+      page = pages
+    }
+    start := (page - 1) * titlesPageSize
+    end := start + titlesPageSize
+    if end > len(bucket.Entries) {
+      end = len(bucket.Entries)
+    }
+    bucket.Entries = bucket.Entries[start:end]
+    data.Buckets = []titleBucket{bucket}
+    if page > 1 {
+      data.Prev = fmt.Sprintf("%s&page=%d", titlesLink(letter), page-1)
+    }
+    if page < pages {
+      data.Next = fmt.Sprintf("%s&page=%d", titlesLink(letter), page+1)
+    }
+  }
+
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if err := tmpl.Execute(w, data); err != nil {
+    fmt.Println("Error rendering titles: ", err)
+  }
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "net/url"
+  "path"
+  "sort"
+  "strings"
+  "time"
+  "golang.org/x/text/collate"
+  "golang.org/x/text/language"
+)
+
+// Node is a path segment in a result tree. Count is the number of leaves
+// at or below it. A leaf with a Link is linked there instead of to its
+// path.
+type Node struct {
+  Path string
+  DisplayName string
+  Link string
+  Modified time.Time
+  Size int64
+  TOC []TOCEntry
+  Count int
+  Children []*Node
+}
+
+// leafInfo is what buildTree knows about a leaf besides its path.
+type leafInfo struct {
+  Title string
+  Link string
+  Modified time.Time
+  Size int64
+  TOC []TOCEntry
+}
+
+// buildTree turns result links like "/static/a/b.html" into a tree of path
+// segments. leaves, keyed by link, optionally sets leaf display names and
+// file metadata.
+func buildTree(links []string, leaves map[string]leafInfo) *Node {
+  root := &Node{}
+  for _, link := range links {
+    parts := strings.Split(link, "/")
+    node := root
+    for _, part := range parts {
+      found := false
+      for _, child := range node.Children {
+        if child.Path == part {
+          node = child
+          found = true
+          break
+        }
+      }
+      if !found {
+        newNode := &Node{Path: part}
+        node.Children = append(node.Children, newNode)
+        node = newNode
+      }
+    }
+    leaf := leaves[link]
+    node.DisplayName = leaf.Title
+    node.Link = leaf.Link
+    node.Modified = leaf.Modified
+    node.Size = leaf.Size
+    node.TOC = leaf.TOC
+  }
+  sortTree(root, currentConfig().TreeOrder)
+  collapseChains(root)
+  countLeaves(root)
+  return root
+}
+
+func countLeaves(node *Node) int {
+  if len(node.Children) == 0 {
+    node.Count = 1
+    return 1
+  }
+  node.Count = 0
+  for _, child := range node.Children {
+    node.Count += countLeaves(child)
+  }
+  return node.Count
+}
+
+const (
+  treeOrderDirsFirst = "dirs-first"
+  treeOrderFilesFirst = "files-first"
+  treeOrderAlpha = "alpha"
+)
+
+// sortTree orders every node's children by name, with directories grouped
+// before or after files depending on order, so rendering is stable no
+// matter which order the walk found the files in. Names are compared with
+// Russian collation, which also handles Latin case-insensitively.
+func sortTree(node *Node, order string) {
+  sortNodes(collate.New(language.Russian, collate.IgnoreCase), node, order)
+}
+
+func sortNodes(c *collate.Collator, node *Node, order string) {
+  sort.SliceStable(node.Children, func(i, j int) bool {
+    a, b := node.Children[i], node.Children[j]
+    aDir, bDir := len(a.Children) > 0, len(b.Children) > 0
+    if aDir != bDir && order != treeOrderAlpha {
+      return aDir == (order != treeOrderFilesFirst)
+    }
+    if cmp := c.CompareString(a.Path, b.Path); cmp != 0 {
+      return cmp < 0
+    }
+    return a.Path < b.Path
+  })
+  for _, child := range node.Children {
+    sortNodes(c, child, order)
+  }
+}
+
+// collapseChains merges directories whose only child is another directory,
+// so "it" > "network" > "vpn" becomes a single "it/network/vpn" node. The
+// unnamed node for the leading slash of links is kept as is.
+func collapseChains(node *Node) {
+  for _, child := range node.Children {
+    for child.Path != "" && len(child.Children) == 1 && len(child.Children[0].Children) > 0 {
+      only := child.Children[0]
+      child.Path += "/" + only.Path
+      child.Children = only.Children
+    }
+    collapseChains(child)
+  }
+}
+
+// ResultGroup holds the results under one top-level directory. Children
+// are built from paths relative to that directory; Prefix is the link path
+// they hang off, for renderNode.
+type ResultGroup struct {
+  Name string
+  Count int
+  Prefix string
+  Children []*Node
+}
+
+// groupResults partitions results for query by the first segment of their
+// path. Results in the wiki root form a group with an empty name, listed
+// first.
+func groupResults(results []SearchResult, query string) []ResultGroup {
+  links := map[string][]string{}
+  leaves := map[string]leafInfo{}
+  var names []string
+  for _, result := range results {
+    name, rest := "", result.Path
+    if i := strings.Index(result.Path, "/"); i >= 0 {
+      name, rest = result.Path[:i], result.Path[i+1:]
+    }
+    if _, ok := links[name]; !ok {
+      names = append(names, name)
+    }
+    links[name] = append(links[name], rest)
+    leaves[name+"/"+rest] = result.leaf(query)
+  }
+  c := collate.New(language.Russian, collate.IgnoreCase)
+  c.SortStrings(names)
+
+  var groups []ResultGroup
+  for _, name := range names {
+    groupLeaves := map[string]leafInfo{}
+    for _, rest := range links[name] {
+      groupLeaves[rest] = leaves[name+"/"+rest]
+    }
+    prefix := strings.TrimPrefix(staticLink(url.PathEscape(name)), "/")
+    if name == "" {
+      prefix = strings.TrimSuffix(prefix, "/")
+    }
+    groups = append(groups, ResultGroup{
+      Name: name,
+      Count: len(links[name]),
+      Prefix: prefix,
+      Children: buildTree(links[name], groupLeaves).Children,
+    })
+  }
+  return groups
+}
+
+// ResultSection holds the results directly in one directory, for the
+// grouped view. Dir is the directory's path, "" for the wiki root.
+type ResultSection struct {
+  Dir string
+  Results []SearchResult
+}
+
+// sectionResults splits results by containing directory. Sections are in
+// directory order, the root first; results within one are in the order of
+// fields, by default by Score, most occurrences first. Ties keep the order
+// of results.
+func sectionResults(results []SearchResult, fields []SortField) []ResultSection {
+  if len(fields) == 0 {
+    fields = []SortField{sortScore}
+  }
+  byDir := map[string][]SearchResult{}
+  var dirs []string
+  for _, result := range results {
+    dir := path.Dir(result.Path)
+    if dir == "." {
+      dir = ""
+    }
+    if _, ok := byDir[dir]; !ok {
+      dirs = append(dirs, dir)
+    }
+    byDir[dir] = append(byDir[dir], result)
+  }
+  c := collate.New(language.Russian, collate.IgnoreCase)
+  c.SortStrings(dirs)
+
+  sections := make([]ResultSection, 0, len(dirs))
+  for _, dir := range dirs {
+    sortByFields(byDir[dir], fields)
+    sections = append(sections, ResultSection{Dir: dir, Results: byDir[dir]})
+  }
+  return sections
+}
+
+// TreeEntry is a file or directory in the /api/tree listing. Directories
+// carry the total size and latest modification time of what they contain.
+type TreeEntry struct {
+  Name string `json:"name"`
+  Path string `json:"path"`
+  Size int64 `json:"size"`
+  Modified time.Time `json:"modified"`
+  Title string `json:"title,omitempty"`
+  Children []*TreeEntry `json:"children,omitempty"`
+}
+
+// fileTree nests the indexed files under a root entry by path segment.
+func fileTree(files []FileEntry) *TreeEntry {
+  root := &TreeEntry{}
+  for _, file := range files {
+    node := root
+    parts := strings.Split(file.Path, "/")
+    for i, part := range parts {
+      var next *TreeEntry
+      for _, child := range node.Children {
+        if child.Name == part {
+          next = child
+          break
+        }
+      }
+      if next == nil {
+        next = &TreeEntry{Name: part, Path: strings.Join(parts[:i+1], "/")}
+        node.Children = append(node.Children, next)
+      }
+      next.Size += file.Size
+      if file.Modified.After(next.Modified) {
+        next.Modified = file.Modified
+      }
+      node = next
+    }
+    node.Title = file.Title
+    root.Size += file.Size
+    if file.Modified.After(root.Modified) {
+      root.Modified = file.Modified
+    }
+  }
+  return root
+}
+
+// handleTree returns the indexed files under ?prefix= as a nested tree, or
+// with ?flat=1 as a sorted list of paths. The ETag changes whenever a file
+// is added, removed or modified.
+func handleTree(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+
+  prefix := strings.TrimPrefix(r.URL.Query().Get("prefix"), "/")
+  files := []FileEntry{}
+  var latest time.Time
+  for _, entry := range currentIndex().Files {
+    if strings.HasPrefix(entry.Path, prefix) {
+      files = append(files, entry)
+      if entry.Modified.After(latest) {
+        latest = entry.Modified
+      }
+    }
+  }
+
+  etag := fmt.Sprintf(`W/"%x-%x"`, latest.UnixNano(), len(files))
+  w.Header().Set("ETag", etag)
+  if r.Header.Get("If-None-Match") == etag {
+    w.WriteHeader(http.StatusNotModified)
+    return
+  }
+
+  if flat := r.URL.Query().Get("flat"); flat == "1" || flat == "true" {
+    paths := []string{}
+    for _, entry := range files {
+      paths = append(paths, entry.Path)
+    }
+    writeJSON(w, http.StatusOK, paths)
+    return
+  }
+  writeJSON(w, http.StatusOK, fileTree(files))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+  "fmt"
+  "strings"
+  "testing"
+)
+
+// shape writes nodes as their paths, with children in brackets.
+func shape(nodes []*Node) string {
+  var parts []string
+  for _, node := range nodes {
+    s := node.Path
+    if len(node.Children) > 0 {
+      s += "[" + shape(node.Children) + "]"
+    }
+    parts = append(parts, s)
+  }
+  return strings.Join(parts, " ")
+}
+
+func TestTreeOrderIgnoresInsertionOrder(t *testing.T) {
+  links := []string{"zeta.html", "b/y.html", "a/x.html", "B2.html", "a/c/z.html", "aa.html", "a/w.html"}
+  want := map[string]string{
+    treeOrderDirsFirst: "a[c[z.html] w.html x.html] b[y.html] aa.html B2.html zeta.html",
+    treeOrderFilesFirst: "aa.html B2.html zeta.html a[w.html x.html c[z.html]] b[y.html]",
+    treeOrderAlpha: "a[c[z.html] w.html x.html] aa.html b[y.html] B2.html zeta.html",
+  }
+  for order, want := range want {
+    useConfig(t, func(c *Config) { c.TreeOrder = order })
+    for i := range links {
+      // Every rotation of the list, forwards and backwards.
+      rotated := append(append([]string{}, links[i:]...), links[:i]...)
+      reversed := make([]string, len(rotated))
+      for j, link := range rotated {
+        reversed[len(rotated)-1-j] = link
+      }
+      for _, list := range [][]string{rotated, reversed} {
+        if got := shape(buildTree(list, nil).Children); got != want {
+          t.Errorf("%s, links %v:\n got %s\nwant %s", order, list, got, want)
+        }
+      }
+    }
+  }
+}
+
+func TestTreeCollapsesChains(t *testing.T) {
+  useConfig(t, nil)
+  tests := []struct {
+    links []string
+    want string
+  }{
+    {[]string{"it/network/vpn/setup.html"}, "it/network/vpn[setup.html]"},
+    {[]string{"it/network/vpn/setup.html", "it/network/vpn/client.html"}, "it/network/vpn[client.html setup.html]"},
+    // network has a file of its own, so only it/ and vpn/ stay apart.
+    {[]string{"it/network/vpn/setup.html", "it/network/hosts.html"}, "it/network[vpn[setup.html] hosts.html]"},
+    {[]string{"b/x.html", "a/only/y.html"}, "a/only[y.html] b[x.html]"},
+    {[]string{"top.html", "a/b/c/d.html"}, "a/b/c[d.html] top.html"},
+  }
+  for _, tt := range tests {
+    if got := shape(buildTree(tt.links, nil).Children); got != tt.want {
+      t.Errorf("%v:\n got %s\nwant %s", tt.links, got, tt.want)
+    }
+  }
+
+  // The node for the leading slash of /static/ links is never merged.
+  root := buildTree([]string{"/static/it/vpn.html"}, nil)
+  if got := shape(root.Children); got != "[static/it[vpn.html]]" {
+    t.Errorf("/static/ link: got %s", got)
+  }
+}
+
+// counts writes nodes as shape does, with each node's Count after it.
+func counts(nodes []*Node) string {
+  var parts []string
+  for _, node := range nodes {
+    s := fmt.Sprintf("%s(%d)", node.Path, node.Count)
+    if len(node.Children) > 0 {
+      s += "[" + counts(node.Children) + "]"
+    }
+    parts = append(parts, s)
+  }
+  return strings.Join(parts, " ")
+}
+
+func TestTreeCountsPropagateUp(t *testing.T) {
+  useConfig(t, nil)
+  root := buildTree([]string{
+    "it/network/vpn/setup.html",
+    "it/network/vpn/client.html",
+    "it/network/hosts.html",
+    "it/printers.html",
+    "top.html",
+  }, nil)
+  if root.Count != 5 {
+    t.Errorf("root count = %d, want 5", root.Count)
+  }
+  want := "it(4)[network(3)[vpn(2)[client.html(1) setup.html(1)] hosts.html(1)] printers.html(1)] top.html(1)"
+  if got := counts(root.Children); got != want {
+    t.Errorf("got  %s\nwant %s", got, want)
+  }
+
+  // A collapsed chain keeps the count of its last directory.
+  root = buildTree([]string{"a/b/c/x.html", "a/b/c/y.html", "z.html"}, nil)
+  if got := counts(root.Children); got != "a/b/c(2)[x.html(1) y.html(1)] z.html(1)" {
+    t.Errorf("collapsed chain: got %s", got)
+  }
+
+  serveDocs(t, map[string]string{
+    "it/network/vpn.html": "<p>printer</p>",
+    "it/network/wifi.html": "<p>printer</p>",
+    "it/printers.html": "<p>printer</p>",
+  }, nil)
+  body := get(handleSearch, "/?q=printer&view=tree").Body.String()
+  for _, want := range []string{`it <span class="count">(3)</span>`, `network <span class="count">(2)</span>`} {
+    if !strings.Contains(body, want) {
+      t.Errorf("page lacks %q", want)
+    }
+  }
+}
+
+func TestGroupResults(t *testing.T) {
+  useConfig(t, nil)
+  var results []SearchResult
+  for _, p := range []string{"it/vpn.html", "root.html", "hr/leave.html", "it/net/hosts.html", "it/net/dns.html", "Ärger/a.html"} {
+    results = append(results, SearchResult{Path: p})
+  }
+  groups := groupResults(results, "q")
+  type group struct {
+    name string
+    count int
+    prefix string
+    tree string
+  }
+  // Collation sorts Ä with A.
+  want := []group{
+    {"", 1, "static", "root.html"},
+    {"Ärger", 1, "static/%C3%84rger", "a.html"},
+    {"hr", 1, "static/hr", "leave.html"},
+    {"it", 3, "static/it", "net[dns.html hosts.html] vpn.html"},
+  }
+  if len(groups) != len(want) {
+    t.Fatalf("got %d groups, want %d", len(groups), len(want))
+  }
+  for i, g := range groups {
+    got := group{g.Name, g.Count, g.Prefix, shape(g.Children)}
+    if got != want[i] {
+      t.Errorf("group %d = %+v, want %+v", i, got, want[i])
+    }
+  }
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "runtime"
+  "runtime/debug"
+  "time"
+)
+
+type BuildInfo struct {
+  Version string `json:"version"`
+  Revision string `json:"revision,omitempty"`
+  CommitTime string `json:"commit_time,omitempty"`
+  Modified bool `json:"modified,omitempty"`
+  GoVersion string `json:"go_version"`
+  StartTime time.Time `json:"start_time"`
+  Uptime string `json:"uptime,omitempty"`
+}
+
+var startTime = time.Now()
+
+func buildInfo() BuildInfo {
+  info := BuildInfo{
+    Version: "(devel)",
+    GoVersion: runtime.Version(),
+    StartTime: startTime,
+  }
+  bi, ok := debug.ReadBuildInfo()
+  if !ok {
+    return info
+  }
+  if bi.Main.Version != "" {
+    info.Version = bi.Main.Version
+  }
+  for _, s := range bi.Settings {
+    switch s.Key {
+    case "vcs.revision":
+      info.Revision = s.Value
+    case "vcs.time":
+      info.CommitTime = s.Value
+    case "vcs.modified":
+      info.Modified = s.Value == "true"
+    }
+  }
+  return info
+}
+
+func (b BuildInfo) String() string {
+  s := fmt.Sprintf("temp-wika %s (%s)", b.Version, b.GoVersion)
+  if b.Revision != "" {
+    s += fmt.Sprintf(" revision %s", b.Revision)
+    if b.Modified {
+      s += "+dirty"
+    }
+  }
+  if b.CommitTime != "" {
+    s += fmt.Sprintf(" committed %s", b.CommitTime)
+  }
+  return s
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  info := buildInfo()
+  info.Uptime = time.Since(startTime).Round(time.Second).String()
+  writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+  "fmt"
+  "net/http"
+  "net/url"
+  "path"
+  "strings"
+  "golang.org/x/net/html"
+  "golang.org/x/net/html/atom"
+)
+
+// firstMatchID is the id of the first highlighted occurrence, which the
+// view page scrolls to unless the link already points somewhere else.
+const firstMatchID = "wika-match"
+
+const viewStyle = `mark.wika-mark { background: #ff0; color: inherit; }
+.wika-view-bar { font: 14px sans-serif; padding: 6px 10px; background: #ffd; border-bottom: 1px solid #cc9; }`
+
+const viewScript = `if (!location.hash) { var m = document.getElementById("` + firstMatchID + `"); if (m) m.scrollIntoView({block: "center"}); }`
+
+// isHTMLPath reports whether the document at p is a page /view can
+// highlight, as opposed to a file only /static/ can serve.
+func isHTMLPath(p string) bool {
+  switch strings.ToLower(path.Ext(logicalPath(p))) {
+  case ".html", ".htm":
+    return true
+  }
+  return false
+}
+
+// viewLink opens the document at p with query highlighted.
+func viewLink(p, query string) string {
+  return "/view?" + url.Values{"path": {p}, "q": {query}}.Encode()
+}
+
+// noHighlight lists elements whose text is never highlighted: it is not
+// shown, or a <mark> inside would change what it does.
+var noHighlight = map[atom.Atom]bool{
+  atom.Head: true,
+  atom.Script: true,
+  atom.Style: true,
+  atom.Noscript: true,
+  atom.Template: true,
+  atom.Textarea: true,
+  atom.Title: true,
+  atom.Svg: true,
+  atom.Math: true,
+  atom.Mark: true,
+}
+
+// highlightMatches wraps every occurrence of query in the text nodes under
+// n in <mark>, matching as the search does, and returns how many it
+// marked. Only text nodes are touched, so tags, attributes and scripts
+// come out as they went in; an occurrence split across elements is not
+// marked.
+func highlightMatches(n *html.Node, query string, loose bool) int {
+  needle, _ := foldRunes([]rune(query), loose)
+  if len(needle) == 0 {
+    return 0
+  }
+  count := 0
+  var walk func(*html.Node)
+  walk = func(n *html.Node) {
+    if n.Type == html.ElementNode && (noHighlight[n.DataAtom] || n.Namespace != "") {
+      return
+    }
+    for c := n.FirstChild; c != nil; {
+      next := c.NextSibling
+      if c.Type == html.TextNode {
+        count += markText(c, needle, loose, count)
+      } else {
+        walk(c)
+      }
+      c = next
+    }
+  }
+  walk(n)
+  return count
+}
+
+// markText splits the text node t around the occurrences of needle,
+// wrapping each in a <mark>. marked is how many were marked before, so
+// the first one overall gets firstMatchID.
+func markText(t *html.Node, needle []rune, loose bool, marked int) int {
+  runes := []rune(t.Data)
+  folded, pos := foldRunes(runes, loose)
+  parent := t.Parent
+  start, count := 0, 0
+  for i := 0; i+len(needle) <= len(folded); i++ {
+    if !hasRunePrefix(folded[i:], needle) {
+      continue
+    }
+    from, to := pos[i], pos[i+len(needle)-1]+1
+    if from > start {
+      parent.InsertBefore(&html.Node{Type: html.TextNode, Data: string(runes[start:from])}, t)
+    }
+    mark := &html.Node{Type: html.ElementNode, Data: "mark", DataAtom: atom.Mark, Attr: []html.Attribute{{Key: "class", Val: "wika-mark"}}}
+    if marked+count == 0 {
+      mark.Attr = append(mark.Attr, html.Attribute{Key: "id", Val: firstMatchID})
+    }
+    mark.AppendChild(&html.Node{Type: html.TextNode, Data: string(runes[from:to])})
+    parent.InsertBefore(mark, t)
+    start = to
+    count++
+    i += len(needle) - 1
+  }
+  if count > 0 {
+    t.Data = string(runes[start:])
+    if t.Data == "" {
+      parent.RemoveChild(t)
+    }
+  }
+  return count
+}
+
+func element(a atom.Atom, attrs ...html.Attribute) *html.Node {
+  return &html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a, Attr: attrs}
+}
+
+func textNode(s string) *html.Node {
+  return &html.Node{Type: html.TextNode, Data: s}
+}
+
+// decorateView adds what the view page needs around the document: a base
+// so its relative links still resolve against /static/, the highlight
+// style, a bar linking to the original and the script that scrolls to the
+// first match. html.Parse always creates <head> and <body>.
+func decorateView(doc *html.Node, p, bar, original string) {
+  head, body := findElement(doc, "head"), findElement(doc, "body")
+  if head == nil || body == nil {
+    return
+  }
+  if findElement(head, "base") == nil {
+    head.InsertBefore(element(atom.Base, html.Attribute{Key: "href", Val: staticLinkEscaped(p)}), head.FirstChild)
+  }
+  style := element(atom.Style)
+  style.AppendChild(textNode(viewStyle))
+  head.AppendChild(style)
+
+  div := element(atom.Div, html.Attribute{Key: "class", Val: "wika-view-bar"})
+  div.AppendChild(textNode(bar + " "))
+  link := element(atom.A, html.Attribute{Key: "href", Val: staticLinkEscaped(p)})
+  link.AppendChild(textNode(original))
+  div.AppendChild(link)
+  body.InsertBefore(div, body.FirstChild)
+
+  script := element(atom.Script)
+  script.AppendChild(textNode(viewScript))
+  body.AppendChild(script)
+}
+
+// handleView serves a document with the occurrences of ?q= highlighted,
+// for links from search results. Without a query, or for documents it
+// can't rewrite, it redirects to the plain /static/ copy.
+func handleView(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  cfg := currentConfig()
+  docs := docsFS(cfg.Directory)
+  file, info, err := resolveDocument(docs, r.URL.Query().Get("path"))
+  if err != nil {
+    renderError(w, r, http.StatusNotFound, "error.not_found_title", "error.no_such_page")
+    return
+  }
+  p := logicalPath(file)
+  query := strings.TrimSpace(r.URL.Query().Get("q"))
+  original := staticLinkEscaped(p)
+  if query == "" || queryTooLong(query) || !isHTMLPath(p) || (cfg.MaxFileSize > 0 && info.Size() > cfg.MaxFileSize) {
+    http.Redirect(w, r, original, http.StatusFound)
+    return
+  }
+
+  content, err := readDocument(r.Context(), docs, file)
+  if err != nil {
+    if r.Context().Err() == nil {
+      fmt.Println("Error reading file", file, ":", err)
+      http.Redirect(w, r, original, http.StatusFound)
+    }
+    return
+  }
+  if isBinary(content) {
+    http.Redirect(w, r, original, http.StatusFound)
+    return
+  }
+  doc, err := parseHTML(r.Context(), content)
+  if err != nil {
+    if r.Context().Err() == nil {
+      http.Redirect(w, r, original, http.StatusFound)
+    }
+    return
+  }
+
+  lang := requestLanguage(r)
+  n := highlightMatches(doc, query, searchOptionsFor(r).Loose)
+  decorateView(doc, p, translate(lang, "view.highlighted", n, query), translate(lang, "view.original"))
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.Header().Set("Cache-Control", "no-cache")
+  if err := html.Render(w, doc); err != nil {
+    fmt.Println("Error rendering highlighted page: ", err)
+  }
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+  "net/http"
+  "strings"
+  "testing"
+  "golang.org/x/net/html"
+)
+
+// highlight renders the body of page with query highlighted, and how many
+// occurrences were marked.
+func highlight(t *testing.T, page, query string) (string, int) {
+  t.Helper()
+  doc, err := html.Parse(strings.NewReader(page))
+  if err != nil {
+    t.Fatal(err)
+  }
+  n := highlightMatches(doc, query, false)
+  var sb strings.Builder
+  for c := findElement(doc, "body").FirstChild; c != nil; c = c.NextSibling {
+    if err := html.Render(&sb, c); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return sb.String(), n
+}
+
+func TestHighlightMatches(t *testing.T) {
+  first := `<mark class="wika-mark" id="wika-match">`
+  mark := `<mark class="wika-mark">`
+  tests := []struct {
+    name string
+    page string
+    want string
+    count int
+  }{
+    {"plain", `<p>VPN and vpn</p>`, `<p>` + first + `VPN</mark> and ` + mark + `vpn</mark></p>`, 2},
+    {"attributes untouched", `<a href="/vpn" title="vpn">the vpn</a>`, `<a href="/vpn" title="vpn">the ` + first + `vpn</mark></a>`, 1},
+    {"script and style untouched", `<p>vpn</p><script>var vpn = 1;</script><style>.vpn{}</style>`, `<p>` + first + `vpn</mark></p><script>var vpn = 1;</script><style>.vpn{}</style>`, 1},
+    {"comments untouched", `<p>x</p><!-- vpn -->`, `<p>x</p><!-- vpn -->`, 0},
+    {"textarea untouched", `<textarea>vpn</textarea>`, `<textarea>vpn</textarea>`, 0},
+    {"svg untouched", `<svg><text>vpn</text></svg>`, `<svg><text>vpn</text></svg>`, 0},
+    {"unclosed tags", `<p>vpn<p><b>vpn`, `<p>` + first + `vpn</mark></p><p><b>` + mark + `vpn</mark></b></p>`, 2},
+    {"stray end tags", `</div>vpn</span>`, first + `vpn</mark>`, 1},
+    {"entities", `<p>a&amp;vpn&lt;</p>`, `<p>a&amp;` + first + `vpn</mark>&lt;</p>`, 1},
+    {"split across elements", `<p>v<b>pn</b></p>`, `<p>v<b>pn</b></p>`, 0},
+  }
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      got, n := highlight(t, tt.page, "vpn")
+      if got != tt.want || n != tt.count {
+        t.Errorf("got %d marked:\n%s\nwant %d:\n%s", n, got, tt.count, tt.want)
+      }
+    })
+  }
+
+  // The query is text, never markup.
+  got, n := highlight(t, `<p>use &lt;b&gt; here</p>`, "<b>")
+  if want := `<p>use ` + first + `&lt;b&gt;</mark> here</p>`; got != want || n != 1 {
+    t.Errorf("query <b>: got %d marked:\n%s\nwant:\n%s", n, got, want)
+  }
+}
+
+func TestViewRedirects(t *testing.T) {
+  serveDocs(t, map[string]string{
+    "guide.html": "<title>Guide</title><p>vpn setup</p>",
+    "notes.txt": "vpn notes",
+  }, nil)
+  w := get(handleView, "/view?path=guide.html&q=vpn")
+  if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `id="wika-match">vpn</mark>`) {
+    t.Errorf("view of guide.html: status %d:\n%s", w.Code, w.Body)
+  }
+  if !strings.Contains(w.Body.String(), `<base href="/static/guide.html"/>`) {
+    t.Errorf("view of guide.html has no base:\n%s", w.Body)
+  }
+  for target, location := range map[string]string{
+    "/view?path=guide.html": "/static/guide.html",
+    "/view?path=notes.txt&q=vpn": "/static/notes.txt",
+  } {
+    w := get(handleView, target)
+    if w.Code != http.StatusFound || w.Header().Get("Location") != location {
+      t.Errorf("%s: status %d, Location %q; want a redirect to %s", target, w.Code, w.Header().Get("Location"), location)
+    }
+  }
+  if w := get(handleView, "/view?path=missing.html&q=vpn"); w.Code != http.StatusNotFound {
+    t.Errorf("missing page: status %d, want 404", w.Code)
+  }
+}
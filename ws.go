@@ -0,0 +1,205 @@
+package main
+
+import (
+  "context"
+  "fmt"
+  "net/http"
+  "net/url"
+  "sync"
+  "time"
+  "golang.org/x/net/websocket"
+)
+
+// wsPingInterval is how often an open /ws/search connection is pinged, well
+// under the idle timeouts of common proxies. wsMaxMessageBytes bounds what
+// a client may send, which is only ever a query.
+const (
+  wsPingInterval = 30 * time.Second
+  wsWriteTimeout = 10 * time.Second
+  wsMaxMessageBytes = 4 << 10
+)
+
+// wsQuery is a message from the client. Each one starts a new search,
+// cancelling the one still running, so a client can search as the user
+// types.
+type wsQuery struct {
+  Q string `json:"q"`
+}
+
+// wsResult is sent for each result as it is found.
+type wsResult struct {
+  Path string `json:"path"`
+  Title string `json:"title"`
+  Snippet string `json:"snippet"`
+}
+
+// wsDone ends the results of query. Truncated is set when maxResults or the
+// search timeout cut them short. wsError reports a query that could not be
+// searched.
+type wsDone struct {
+  Query string `json:"query"`
+  Done bool `json:"done"`
+  Total int `json:"total"`
+  Truncated bool `json:"truncated,omitempty"`
+}
+
+type wsError struct {
+  Query string `json:"query"`
+  Error string `json:"error"`
+}
+
+// wsSameOrigin refuses handshakes from pages on other sites, which could
+// otherwise read the wiki through a browser inside the allowed ranges.
+// Clients that send no Origin, such as scripts, are not browsers and pass.
+func wsSameOrigin(config *websocket.Config, r *http.Request) error {
+  origin := r.Header.Get("Origin")
+  if origin == "" {
+    return nil
+  }
+  u, err := url.Parse(origin)
+  if err != nil || u.Host != r.Host {
+    return fmt.Errorf("origin %q not allowed", origin)
+  }
+  config.Origin = u
+  return nil
+}
+
+// handleSearchWS runs searches sent over a WebSocket and sends each result
+// back as soon as it is found, followed by a done message with the total.
+// Access is checked before the upgrade, like any other page.
+func handleSearchWS(w http.ResponseWriter, r *http.Request) {
+  if !checkAccess(w, r) {
+    return
+  }
+  websocket.Server{Handshake: wsSameOrigin, Handler: serveSearchWS}.ServeHTTP(w, r)
+}
+
+func serveSearchWS(ws *websocket.Conn) {
+  defer ws.Close()
+  ws.MaxPayloadBytes = wsMaxMessageBytes
+  ctx, cancel := context.WithCancel(ws.Request().Context())
+  defer cancel()
+
+  send := func(v interface{}) error {
+    ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+    return websocket.JSON.Send(ws, v)
+  }
+  ws.PayloadType = websocket.PingFrame
+  go pingWS(ctx, ws)
+
+  var wg sync.WaitGroup
+  defer wg.Wait()
+  stop := func() {}
+  defer func() { stop() }()
+  for {
+    var msg wsQuery
+    if err := websocket.JSON.Receive(ws, &msg); err != nil {
+      return
+    }
+    stop()
+    searchCtx, cancelSearch := context.WithCancel(ctx)
+    done := make(chan struct{})
+    stop = func() {
+      cancelSearch()
+      <-done
+    }
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      defer close(done)
+      if err := searchWS(searchCtx, msg.Q, send); err != nil {
+        cancel()
+      }
+    }()
+  }
+}
+
+// searchWS runs one query, passing results from the search through a
+// channel to send. It returns an error only when sending failed, which
+// means the connection is gone.
+func searchWS(ctx context.Context, q string, send func(interface{}) error) error {
+  query, err, _ := validateQuery(q)
+  if err != nil {
+    return send(wsError{Query: q, Error: err.Error()})
+  }
+  if building, _ := indexBuilding(); building {
+    return send(wsError{Query: query, Error: "index is being built"})
+  }
+
+  cfg := currentConfig()
+  searchCtx, cancel := context.WithCancel(ctx)
+  if timeout := cfg.SearchTimeoutSeconds; timeout > 0 {
+    searchCtx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+  }
+  defer cancel()
+  opts := searchOptions{Loose: cfg.LooseMatch, MaxMatches: 1}
+  results := make(chan SearchResult)
+  searchErr := make(chan error, 1)
+  go func() {
+    defer close(results)
+    searchErr <- searchDocuments(searchCtx, docsFS(cfg.Directory), query, opts, func(result SearchResult) error {
+      select {
+      case results <- result:
+        return nil
+      case <-searchCtx.Done():
+        return searchCtx.Err()
+      }
+    })
+  }()
+
+  done := wsDone{Query: query, Done: true}
+  var sendErr error
+  for result := range results {
+    if sendErr != nil || done.Truncated {
+      continue
+    }
+    msg := wsResult{Path: result.Path, Title: result.Title}
+    if len(result.Matches) > 0 {
+      msg.Snippet = result.Matches[0].Snippet
+    }
+    if sendErr = send(msg); sendErr != nil {
+      cancel()
+      continue
+    }
+    done.Total++
+    if cfg.MaxResults > 0 && done.Total >= cfg.MaxResults {
+      done.Truncated = true
+      cancel()
+    }
+  }
+  err = <-searchErr
+  switch {
+  case sendErr != nil:
+    return sendErr
+  case ctx.Err() != nil:
+    // Replaced by a newer query, or the connection is closing.
+    return nil
+  case searchCtx.Err() == context.DeadlineExceeded:
+    done.Truncated = true
+  case err != nil && !done.Truncated:
+    fmt.Println("Error searching files for", query, "over WebSocket: ", err)
+    return send(wsError{Query: query, Error: "error searching files"})
+  }
+  return send(done)
+}
+
+// pingWS pings the client every wsPingInterval until ctx is done, so
+// proxies don't drop the connection while the user isn't searching.
+// Browsers answer pings on their own, and the websocket package reads and
+// discards the pongs. Messages go out through websocket.JSON, which sets
+// its own frame type, so ws.Write is set up to send pings.
+func pingWS(ctx context.Context, ws *websocket.Conn) {
+  ticker := time.NewTicker(wsPingInterval)
+  defer ticker.Stop()
+  for {
+    select {
+    case <-ctx.Done():
+      return
+    case <-ticker.C:
+      ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+      if _, err := ws.Write(nil); err != nil {
+        return
+      }
+    }
+  }
+}